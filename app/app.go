@@ -2,32 +2,54 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
 	. "github.com/tendermint/go-common"
 	"github.com/tendermint/go-wire"
 	tmsp "github.com/tendermint/tmsp/types"
 	sm "github.com/zballs/comit/state"
 	. "github.com/zballs/comit/types"
 	. "github.com/zballs/comit/util"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const version = "1.0.0"
 
+// state is the committed state backed by the merkle client.
+// AppendTx runs against the block cache, which is synced on
+// Commit; CheckTx runs against checkState, which is dropped.
+
 type App struct {
-	cli    *Client
-	state  *sm.State
-	cache  *sm.State
-	Issues []string
+	cli        *Client
+	state      *sm.State
+	cache      *sm.State
+	checkState *sm.State
+	height     int
+	Issues     []string
+
+	// Loaded into the tree at InitChain
+	snapshot *Snapshot
 }
 
 func NewApp(cli *Client) *App {
 	state := sm.NewState(cli)
-	return &App{
+	app := &App{
 		cli:   cli,
 		state: state,
-		cache: nil,
 	}
+	app.resetCaches()
+	return app
+}
+
+// Both caches execute at the next block height
+
+func (app *App) resetCaches() {
+	app.cache = app.state.CacheWrap()
+	app.cache.SetBlockHeight(app.height + 1)
+	app.checkState = app.state.CacheWrap()
+	app.checkState.SetBlockHeight(app.height + 1)
 }
 
 // State filters
@@ -35,26 +57,20 @@ func NewApp(cli *Client) *App {
 func (app *App) SetFilters() {
 	names := app.Issues // and locations
 	app.state.SetFilters(names)
+	app.resetCaches()
 }
 
 // Search pipeline
 
-func (app *App) IterQuery(in chan []byte) {
-	query := EmptyQuery(QuerySize)
-	result := app.Query(query)
-	if result.IsErr() {
-		panic(result.Error())
-	}
-	var size int
-	wire.ReadBinaryBytes(result.Data, &size)
-	for i := 0; i < size; i++ {
-		query := IndexQuery(i)
-		result := app.Query(query)
-		if result.IsErr() {
-			panic(result.Error())
-		}
-		in <- result.Data
-	}
+// Scans the time index, which holds form IDs
+// in order of the block time they were committed
+
+func (app *App) IterQuery(start, end []byte, in chan []byte, errc chan error) {
+	_, err := app.state.Iterate(start, end, func(_, formID []byte) bool {
+		in <- formID
+		return false
+	})
+	errc <- err
 	close(in)
 }
 
@@ -83,19 +99,18 @@ func (app *App) IterResult(out chan []byte) [][]byte {
 	return datas
 }
 
-func TimeRangefunc(afterTime, beforeTime time.Time) func([]byte) bool {
-	return func(data []byte) bool {
-		var form Form
-		err := wire.ReadBinaryBytes(data, &form)
-		if err != nil {
-			panic(err)
-		}
-		t := ParseMinuteString(form.SubmittedAt)
-		if t.After(afterTime) && t.Before(beforeTime) {
-			return true
-		}
-		return false
+// Zero times leave the range open at that end
+
+func TimeRange(afterTime, beforeTime time.Time) (start, end []byte) {
+	var after int64
+	if !afterTime.IsZero() {
+		after = afterTime.Unix()
+	}
+	start, end = sm.FormTimeRange(after, beforeTime.Unix())
+	if beforeTime.IsZero() {
+		end = PrefixEnd([]byte(sm.FormTimePrefix))
 	}
+	return start, end
 }
 
 func XORfunc(items ...string) func([]byte) []byte {
@@ -115,6 +130,7 @@ func (app *App) SetOption(key string, value string) (log string) {
 	switch key {
 	case "chainID":
 		app.state.SetChainID(value)
+		app.resetCaches()
 		return "Success"
 	case "issue":
 		app.Issues = append(app.Issues, value)
@@ -128,10 +144,37 @@ func (app *App) SetOption(key string, value string) (log string) {
 		}
 		app.state.SetAccount(acc.PubKey.Address(), acc)
 		return "Success"
+	case "upgrade":
+		var upgrade Upgrade
+		err := json.Unmarshal([]byte(value), &upgrade)
+		if err != nil {
+			return "Error decoding upgrade message: " + err.Error()
+		}
+		err = app.state.ScheduleUpgrade(upgrade)
+		if err != nil {
+			return "Error scheduling upgrade: " + err.Error()
+		}
+		return "Success"
+	case "keep_versions":
+		// Pruning of historical state in merkle-cli
+		res := app.cli.SetOptionSync(key, value)
+		if res.IsErr() {
+			return res.Error()
+		}
+		return res.Log
 	}
 	return "Unrecognized option key " + key
 }
 
+// Keeps the last n versions of state for height queries
+func (app *App) SetKeepVersions(n int) error {
+	log := app.SetOption("base/keep_versions", strconv.Itoa(n))
+	if log != "Success" {
+		return errors.New(log)
+	}
+	return nil
+}
+
 func (app *App) AppendTx(tx []byte) tmsp.Result {
 	var action Action
 	err := wire.ReadBinaryBytes(tx, &action)
@@ -139,7 +182,7 @@ func (app *App) AppendTx(tx []byte) tmsp.Result {
 		return tmsp.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
 	}
 	// Validate and exec action tx
-	res := sm.ExecuteAction(app.state, action, false)
+	res := sm.ExecuteAction(app.cache, action, false)
 	if res.IsErr() {
 		return res.PrependLog("Error in AppendTx")
 	}
@@ -152,8 +195,13 @@ func (app *App) CheckTx(tx []byte) tmsp.Result {
 	if err != nil {
 		return tmsp.ErrBaseEncodingError.AppendLog("Error decoding tx: " + err.Error())
 	}
+	// Client timestamps are checked against our clock
+	res := sm.CheckActionTime(action, time.Now())
+	if res.IsErr() {
+		return res.PrependLog("Error in CheckTx")
+	}
 	// Validate and exec action
-	res := sm.ExecuteAction(app.state, action, true)
+	res = sm.ExecuteAction(app.checkState, action, true)
 	if res.IsErr() {
 		return res.PrependLog("Error in CheckTx")
 	}
@@ -166,10 +214,26 @@ func (app *App) Query(query []byte) tmsp.Result {
 
 	switch queryType {
 
-	case QueryValue, QueryIndex, QuerySize, QueryProof:
+	case QueryValue, QueryIndex, QuerySize, QueryProof, QueryAbsenceProof, QueryRange:
 		// merkle-cli
 		return app.cli.QuerySync(query)
 
+	case QueryAtHeight:
+		height, n, err := wire.GetVarint(query[1:])
+		if err != nil {
+			return tmsp.ErrEncodingError.AppendLog("Failed to decode height")
+		}
+		// State outside the tree is added to the tree's
+		// state at height; other queries go to merkle-cli
+		if len(query) == 2+n && query[1+n] == QueryState {
+			return app.queryState(height)
+		}
+		return app.cli.QuerySync(query)
+
+	case QueryChainID:
+		data := wire.BinaryBytes([]byte(app.state.GetChainID()))
+		return tmsp.NewResultOK(data, "")
+
 	case QueryIssues:
 
 		buf, n, err := new(bytes.Buffer), int(0), error(nil)
@@ -180,6 +244,21 @@ func (app *App) Query(query []byte) tmsp.Result {
 		data := buf.Bytes()
 		return tmsp.NewResultOK(data, "")
 
+	case QuerySequence:
+		addr, _, err := wire.GetByteSlice(query[1:])
+		if err != nil {
+			return tmsp.ErrEncodingError.AppendLog("Failed to decode address")
+		}
+		acc := app.checkState.GetAccount(addr)
+		if acc == nil {
+			return tmsp.ErrBaseUnknownAddress
+		}
+		data := wire.BinaryBytes(acc.Sequence)
+		return tmsp.NewResultOK(data, "")
+
+	case QueryState:
+		return app.queryState(0)
+
 	case QuerySearch:
 		data, _, err := wire.GetByteSlice(query[1:])
 		if err != nil {
@@ -190,20 +269,23 @@ func (app *App) Query(query []byte) tmsp.Result {
 		if err != nil {
 			return tmsp.ErrEncodingError
 		}
-		// Checks if forms are in filter(s)
-		fun1 := app.state.Filterfunc(s.Issue) //s.Location
+		// Forms in time range, by block time
+		start, end := TimeRange(s.After, s.Before)
 
-		// Checks if forms are in time range
-		fun2 := TimeRangefunc(s.After, s.Before)
+		// Checks if forms are in filter(s)
+		fun := app.state.Filterfunc(s.Issue) //s.Location
 
 		ch1 := make(chan []byte)
 		ch2 := make(chan []byte)
-		ch3 := make(chan []byte)
+		errc := make(chan error, 1)
 
-		go app.IterQuery(ch1)
-		go app.IterCheck(fun1, ch1, ch2)
-		go app.IterCheck(fun2, ch2, ch3)
-		datas := app.IterResult(ch3)
+		go app.IterQuery(start, end, ch1, errc)
+		go app.IterCheck(fun, ch1, ch2)
+		datas := app.IterResult(ch2)
+
+		if err := <-errc; err != nil {
+			return tmsp.ErrInternalError.SetLog("Error reading forms: " + err.Error())
+		}
 
 		if len(datas) == 0 {
 			return tmsp.NewResultOK(nil, "")
@@ -217,29 +299,65 @@ func (app *App) Query(query []byte) tmsp.Result {
 	}
 }
 
+func (app *App) queryState(height int) tmsp.Result {
+	snap, err := app.exportState(height)
+	if err != nil {
+		return tmsp.ErrInternalError.SetLog(err.Error())
+	}
+	data := wire.BinaryBytes(*snap)
+	return tmsp.NewResultOK(data, "")
+}
+
 func (app *App) Commit() (res tmsp.Result) {
+	// Write the block's state to the merkle tree
+	// before asking for the new hash
+	err := app.cache.CacheSync()
+	if err != nil {
+		PanicSanity("Error syncing block state: " + err.Error())
+	}
 	res = app.cli.CommitSync()
 	if res.IsErr() {
 		PanicSanity("Error getting hash: " + res.Error())
 	}
+	app.resetCaches()
 	return res
 }
 
 // TMSP::InitChain
 func (app *App) InitChain(validators []*tmsp.Validator) {
 	app.cli.InitChainSync(validators)
+	if app.snapshot != nil {
+		err := app.loadSnapshot()
+		if err != nil {
+			PanicSanity("Error importing state: " + err.Error())
+		}
+	}
+	// The new chain's validators replace any imported set
+	err := app.state.InitValidators(validators)
+	if err != nil {
+		PanicSanity("Error setting validators: " + err.Error())
+	}
+	app.resetCaches()
 }
 
 // TMSP::BeginBlock
 func (app *App) BeginBlock(height uint64) {
 	app.cli.BeginBlockSync(height)
 	app.cache = app.state.CacheWrap()
+	app.cache.SetBlockHeight(int(height))
+	app.height = int(height)
+	// Halt if the rules at this height are newer than we know
+	err := app.cache.CheckVersion()
+	if err != nil {
+		PanicSanity(err.Error())
+	}
 }
 
 // TMSP::EndBlock
-func (app *App) EndBlock(height uint64) (vz []*tmsp.Validator) {
-	vz, _ = app.cli.EndBlockSync(height)
-	return vz
+func (app *App) EndBlock(height uint64) (diffs []*tmsp.Validator) {
+	diffs = sm.EndBlock(app.cache)
+	app.cli.EndBlockSync(height)
+	return diffs
 }
 
 // -----------------------------------------
@@ -94,6 +94,7 @@ func TestStream(t *testing.T) {
 
 	// (1) Create account
 	pubKey, privKey := CreateAccount(username, password, app, w, t)
+	app.Commit()
 
 	// (2) Query account
 	acc := QueryAccount(pubKey, app, w, t)
@@ -110,6 +111,7 @@ func TestStream(t *testing.T) {
 	privAcc.Sequence++
 	formID2 := SubmitForm(issue2, location2, description2, privAcc, app, w, t)
 	fmt.Printf("%X\n", formID2)
+	app.Commit()
 
 	// (5,6) Query forms
 	form1 := QueryForm(formID1, app, w, t)
@@ -153,7 +155,7 @@ func QueryAccount(pubKey crypto.PubKey, app *App, w *bufio.Writer, t *testing.T)
 
 	// Create query
 	accKey := state.AccountKey(pubKey.Address())
-	query := KeyQuery(accKey)
+	query := KeyQuery(accKey, QueryValue)
 
 	// Query account
 	result := app.Query(query)
@@ -203,7 +205,7 @@ func SubmitForm(issue, location, description string, privAcc *PrivAccount, app *
 func QueryForm(formID []byte, app *App, w *bufio.Writer, t *testing.T) Form {
 
 	// Create query
-	query := KeyQuery(formID)
+	query := KeyQuery(state.FormKey(formID), QueryValue)
 
 	// Query form
 	result := app.Query(query)
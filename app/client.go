@@ -1,9 +1,11 @@
 package app
 
 import (
+	"github.com/pkg/errors"
 	"github.com/tendermint/go-wire"
 	tmspcli "github.com/tendermint/tmsp/client"
 	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
 	. "github.com/zballs/comit/util"
 	"log"
 )
@@ -62,6 +64,14 @@ func (cli *Client) SetSync(key []byte, value []byte) tmsp.Result {
 	return cli.AppendTxSync(txBytes)
 }
 
+func (cli *Client) RangeSync(start, end []byte, limit int) (res tmsp.Result) {
+	query := RangeQuery(start, end, limit)
+	if query == nil {
+		return tmsp.ErrInternalError.SetLog("Error encoding range query")
+	}
+	return cli.QuerySync(query)
+}
+
 func (cli *Client) RemSync(key []byte) tmsp.Result {
 	tx := make([]byte, wire.ByteSliceSize(key)+1)
 	buf := tx
@@ -86,6 +96,10 @@ func (client *Client) Get(key []byte) (value []byte) {
 }
 
 func (client *Client) Set(key []byte, value []byte) {
+	if value == nil {
+		client.Remove(key)
+		return
+	}
 	res := client.SetSync(key, value)
 	if res.IsErr() {
 		log.Println(res.Error())
@@ -98,3 +112,30 @@ func (client *Client) Remove(key []byte) {
 		log.Println(res.Error())
 	}
 }
+
+// Iterate reads the range a page at a time; each page
+// starts just after the last key of the one before
+
+func (client *Client) Iterate(start, end []byte, fun func(key, value []byte) bool) (bool, error) {
+	for {
+		res := client.RangeSync(start, end, MaxRangeLimit)
+		if res.IsErr() {
+			return false, errors.New(res.Error())
+		}
+		var kvz []KVPair
+		err := wire.ReadBinaryBytes(res.Data, &kvz)
+		if err != nil {
+			return false, errors.Wrap(err, "decoding range")
+		}
+		for _, kv := range kvz {
+			if fun(kv.Key, kv.Value) {
+				return true, nil
+			}
+		}
+		if len(kvz) < MaxRangeLimit {
+			return false, nil
+		}
+		last := kvz[len(kvz)-1].Key
+		start = append(append([]byte{}, last...), 0x00)
+	}
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+	sm "github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Genesis is validated as a whole before any of it is applied
+
+type Genesis struct {
+	ChainID  string           `json:"chain_id"`
+	Issues   []string         `json:"issues"`
+	Accounts []GenesisAccount `json:"accounts"`
+	Upgrades []Upgrade        `json:"upgrades,omitempty"`
+
+	// Block time starts here, see state.MaxBlockTime
+	GenesisTime time.Time `json:"genesis_time"`
+}
+
+type GenesisAccount struct {
+	PubKey   string   `json:"pub_key"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+
+	// Carried over by ExportGenesis, so actions signed
+	// on the old chain can't be replayed on the new one
+	Sequence int      `json:"sequence,omitempty"`
+	FormIDs  []string `json:"form_ids,omitempty"`
+}
+
+var knownRoles = map[string]bool{
+	RoleAdmin: true,
+}
+
+func LoadGenesis(filePath string) (*Genesis, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading genesis file")
+	}
+	gen, err := ParseGenesis(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing genesis file %s", filePath)
+	}
+	return gen, nil
+}
+
+// Unknown fields are errors, so typos don't go unnoticed.
+// The older [key1, value1, key2, value2, ...] format is
+// still read, with the same checks.
+
+func ParseGenesis(data []byte) (*Genesis, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		return parseLegacyGenesis(data)
+	}
+	gen := &Genesis{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(gen)
+	if err != nil {
+		return nil, err
+	}
+	return gen, nil
+}
+
+func parseLegacyGenesis(data []byte) (*Genesis, error) {
+	var items []json.RawMessage
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return nil, err
+	}
+	if len(items)%2 != 0 {
+		return nil, errors.New("genesis cannot have an odd number of items. Format = [key1, value1, key2, value2, ...]")
+	}
+	gen := &Genesis{}
+	for i := 0; i < len(items); i += 2 {
+		var key string
+		err := json.Unmarshal(items[i], &key)
+		if err != nil {
+			return nil, errors.Errorf("item %d: key must be a string, got %s", i, items[i])
+		}
+		value := items[i+1]
+		switch key {
+		case "base/chainID":
+			err = json.Unmarshal(value, &gen.ChainID)
+		case "base/issue":
+			var issue string
+			err = json.Unmarshal(value, &issue)
+			gen.Issues = append(gen.Issues, issue)
+		case "base/account":
+			var acc *Account
+			wire.ReadJSONPtr(&acc, value, &err)
+			if err == nil {
+				gen.Accounts = append(gen.Accounts, GenesisAccount{
+					PubKey:   PubKeytoHexstr(acc.PubKey),
+					Username: acc.Username,
+					Roles:    acc.Roles,
+					Sequence: acc.Sequence,
+					FormIDs:  acc.FormIDs,
+				})
+			}
+		case "base/upgrade":
+			var upgrade Upgrade
+			err = json.Unmarshal(value, &upgrade)
+			gen.Upgrades = append(gen.Upgrades, upgrade)
+		case "base/genesis_time":
+			err = json.Unmarshal(value, &gen.GenesisTime)
+		default:
+			return nil, errors.Errorf("item %d: unrecognized key %q", i, key)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "item %d: invalid value for %q", i, key)
+		}
+	}
+	return gen, nil
+}
+
+// Validate reports every problem at once
+
+func (gen *Genesis) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, Fmt(format, args...))
+	}
+	if strings.TrimSpace(gen.ChainID) == "" {
+		addf("chain_id is required")
+	}
+	if len(gen.Issues) == 0 {
+		addf("at least one issue is required")
+	}
+	if gen.GenesisTime.IsZero() {
+		addf("genesis_time is required")
+	}
+	issues := make(map[string]bool)
+	for i, issue := range gen.Issues {
+		if strings.TrimSpace(issue) == "" {
+			addf("issues[%d] is empty", i)
+		} else if issues[issue] {
+			addf("issues[%d]: duplicate issue %q", i, issue)
+		}
+		issues[issue] = true
+	}
+	addrs := make(map[string]bool)
+	for i, genAcc := range gen.Accounts {
+		pubKey, err := PubKeyfromHexstr(genAcc.PubKey)
+		if err != nil {
+			addf("accounts[%d]: invalid pub_key %q", i, genAcc.PubKey)
+			continue
+		}
+		addr := BytesToHexstr(pubKey.Address())
+		if addrs[addr] {
+			addf("accounts[%d]: duplicate account %s", i, addr)
+		}
+		addrs[addr] = true
+		for _, role := range genAcc.Roles {
+			if !knownRoles[role] {
+				addf("accounts[%d]: unknown role %q", i, role)
+			}
+		}
+		if genAcc.Sequence < 0 {
+			addf("accounts[%d]: negative sequence %d", i, genAcc.Sequence)
+		}
+	}
+	last := Upgrade{0, sm.InitialVersion}
+	for i, upgrade := range gen.Upgrades {
+		if upgrade.Height <= last.Height {
+			addf("upgrades[%d]: height %d must be after %d", i, upgrade.Height, last.Height)
+		}
+		if upgrade.Version <= last.Version {
+			addf("upgrades[%d]: version %d must be greater than %d", i, upgrade.Version, last.Version)
+		}
+		if upgrade.Version > sm.MaxSupportedVersion {
+			addf("upgrades[%d]: version %d is newer than supported version %d", i, upgrade.Version, sm.MaxSupportedVersion)
+		}
+		last = upgrade
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("invalid genesis:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (genAcc GenesisAccount) Account() (*Account, error) {
+	pubKey, err := PubKeyfromHexstr(genAcc.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	acc := NewAccount(pubKey, genAcc.Username)
+	acc.Roles = genAcc.Roles
+	acc.Sequence = genAcc.Sequence
+	acc.FormIDs = genAcc.FormIDs
+	return acc, nil
+}
+
+func (app *App) ApplyGenesis(gen *Genesis) error {
+	err := gen.Validate()
+	if err != nil {
+		return err
+	}
+	app.state.SetChainID(gen.ChainID)
+	app.state.SetGenesisTime(gen.GenesisTime.Unix())
+	app.Issues = append(app.Issues, gen.Issues...)
+	for _, genAcc := range gen.Accounts {
+		acc, _ := genAcc.Account()
+		app.state.SetAccount(acc.PubKey.Address(), acc)
+	}
+	for _, upgrade := range gen.Upgrades {
+		err = app.state.ScheduleUpgrade(upgrade)
+		if err != nil {
+			// Validate should have caught this
+			return err
+		}
+	}
+	app.resetCaches()
+	return nil
+}
+
+// ExportGenesis reads the committed state of a running
+// node back into a genesis document
+
+func ExportGenesis(proxy *Proxy) (*Genesis, error) {
+	gen := &Genesis{}
+
+	data, err := queryData(proxy, EmptyQuery(QueryChainID))
+	if err != nil {
+		return nil, errors.Wrap(err, "querying chain ID")
+	}
+	chainID, _, err := wire.GetByteSlice(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding chain ID")
+	}
+	gen.ChainID = string(chainID)
+
+	data, err = queryData(proxy, EmptyQuery(QueryIssues))
+	if err != nil {
+		return nil, errors.Wrap(err, "querying issues")
+	}
+	err = wire.ReadBinaryBytes(data, &gen.Issues)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding issues")
+	}
+
+	kvz, err := queryPrefix(proxy, []byte(sm.AccountPrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "querying accounts")
+	}
+	for _, kv := range kvz {
+		acc, err := DecodeAccount(kv.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding account %X", kv.Key)
+		}
+		gen.Accounts = append(gen.Accounts, GenesisAccount{
+			PubKey:   PubKeytoHexstr(acc.PubKey),
+			Username: acc.Username,
+			Roles:    acc.Roles,
+			Sequence: acc.Sequence,
+			FormIDs:  acc.FormIDs,
+		})
+	}
+
+	data, err = queryData(proxy, KeyQuery([]byte(sm.UpgradesKey), QueryValue))
+	if err == nil {
+		err = wire.ReadBinaryBytes(data, &gen.Upgrades)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding upgrades")
+		}
+	}
+
+	// The new chain starts at the last block time
+	gen.GenesisTime = time.Now().UTC()
+	data, err = queryData(proxy, KeyQuery([]byte(sm.BlockTimeKey), QueryValue))
+	if err == nil {
+		var blockTime int64
+		err = wire.ReadBinaryBytes(data, &blockTime)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding block time")
+		}
+		gen.GenesisTime = time.Unix(blockTime, 0).UTC()
+	}
+
+	return gen, gen.Validate()
+}
+
+// Range queries return at most MaxRangeLimit pairs,
+// so we page until a short page comes back
+
+func queryPrefix(proxy *Proxy, prefix []byte) (kvz []KVPair, err error) {
+	start, end := prefix, PrefixEnd(prefix)
+	for {
+		data, err := queryData(proxy, RangeQuery(start, end, MaxRangeLimit))
+		if err != nil {
+			return nil, err
+		}
+		var page []KVPair
+		err = wire.ReadBinaryBytes(data, &page)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding range")
+		}
+		kvz = append(kvz, page...)
+		if len(page) < MaxRangeLimit {
+			return kvz, nil
+		}
+		last := page[len(page)-1].Key
+		start = append(append([]byte{}, last...), 0x00)
+	}
+}
+
+func queryData(proxy *Proxy, query []byte) ([]byte, error) {
+	result, err := proxy.TMSPQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	err = ResultToError(result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Result.Data, nil
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"github.com/tendermint/go-crypto"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"strings"
+	"testing"
+)
+
+const testPubKey = "E93790067FA5106F71F91635FC388348C1A864E3C14ED35DAB0C754356425334"
+
+func TestParseGenesis(t *testing.T) {
+	gen, err := ParseGenesis([]byte(`{
+		"chain_id": "comit",
+		"issues": ["pothole"],
+		"accounts": [{"pub_key": "` + testPubKey + `", "username": "someone", "roles": ["admin"]}],
+		"genesis_time": "2016-11-03T14:05:00Z"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gen.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Typo in a field name
+	_, err = ParseGenesis([]byte(`{"chain_id": "comit", "isues": ["pothole"]}`))
+	if err == nil {
+		t.Error("Expected unknown field to be rejected")
+	}
+
+	// Typo in a legacy key
+	_, err = ParseGenesis([]byte(`["base/chainID", "comit", "base/isue", "pothole"]`))
+	if err == nil || !strings.Contains(err.Error(), "base/isue") {
+		t.Errorf("Expected unrecognized key error, got %v", err)
+	}
+}
+
+func TestValidateGenesisReportsAllProblems(t *testing.T) {
+	gen := &Genesis{
+		Issues: []string{"pothole", "pothole"},
+		Accounts: []GenesisAccount{
+			{PubKey: "XYZ"},
+			{PubKey: testPubKey, Roles: []string{"mayor"}},
+		},
+	}
+	err := gen.Validate()
+	if err == nil {
+		t.Fatal("Expected invalid genesis")
+	}
+	for _, problem := range []string{"chain_id", "duplicate issue", "genesis_time", "invalid pub_key", "unknown role"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in error: %v", problem, err)
+		}
+	}
+}
+
+func TestApplyGenesisSequence(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519()
+	gen, err := ParseGenesis([]byte(`{
+		"chain_id": "` + chainID + `",
+		"issues": ["pothole"],
+		"accounts": [{"pub_key": "` + PubKeytoHexstr(privKey.PubKey()) + `", "username": "someone", "sequence": 7, "form_ids": ["A"]}],
+		"genesis_time": "2016-11-03T14:05:00Z"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(NewLocalClient())
+	err = app.ApplyGenesis(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := app.state.GetAccount(privKey.PubKey().Address())
+	if acc == nil || acc.Sequence != 7 || len(acc.FormIDs) != 1 {
+		t.Fatalf("Unexpected account: %v", acc)
+	}
+
+	// An action signed on the old chain at an earlier sequence
+	action := NewAction(ActionRemoveAccount, nil)
+	action.Prepare(privKey.PubKey(), 3)
+	action.Sign(privKey, chainID)
+	if res := app.AppendTx(action.Tx()); res.IsOK() {
+		t.Error("Expected replayed action to be rejected")
+	}
+}
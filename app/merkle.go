@@ -1,24 +1,44 @@
 package app
 
 import (
+	"bytes"
 	. "github.com/tendermint/go-common"
 	"github.com/tendermint/go-merkle"
 	"github.com/tendermint/go-wire"
 	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
 	. "github.com/zballs/comit/util"
+	"strconv"
 )
 
 const (
-	ErrValueNotFound = 10000
+	ErrValueNotFound   = CodeValueNotFound
+	ErrVersionNotFound = CodeVersionNotFound
 )
 
+// The tree is copied at every commit, so queries can read state
+// as of an earlier height. Copies share unchanged nodes. Versions
+// older than the latest keepVersions are pruned. Zero keeps all,
+// so memory grows with the chain; it must be set explicitly.
+
+const DefaultKeepVersions = 100
+
 type MerkleApp struct {
 	tree *merkle.IAVLTree
+
+	height       int
+	committed    int
+	versions     map[int]*merkle.IAVLTree
+	keepVersions int
 }
 
 func NewMerkleApp() *MerkleApp {
 	tree := merkle.NewIAVLTree(0, nil)
-	return &MerkleApp{tree}
+	return &MerkleApp{
+		tree:         tree,
+		versions:     make(map[int]*merkle.IAVLTree),
+		keepVersions: DefaultKeepVersions,
+	}
 }
 
 func (merk *MerkleApp) Info() string {
@@ -26,7 +46,17 @@ func (merk *MerkleApp) Info() string {
 }
 
 func (merk *MerkleApp) SetOption(key string, value string) (log string) {
-	return "No options are supported yet"
+	switch key {
+	case "keep_versions":
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 0 {
+			return "Error keep_versions must be a non-negative integer"
+		}
+		merk.keepVersions = keep
+		merk.prune()
+		return "Success"
+	}
+	return "Unrecognized option key " + key
 }
 
 func (merk *MerkleApp) AppendTx(tx []byte) tmsp.Result {
@@ -82,7 +112,22 @@ func (merk *MerkleApp) CheckTx(tx []byte) tmsp.Result {
 	return tmsp.OK
 }
 
+// TMSP::InitChain
+func (merk *MerkleApp) InitChain(validators []*tmsp.Validator) {
+}
+
+// TMSP::BeginBlock
+func (merk *MerkleApp) BeginBlock(height uint64) {
+	merk.height = int(height)
+}
+
+// TMSP::EndBlock
+func (merk *MerkleApp) EndBlock(height uint64) []*tmsp.Validator {
+	return nil
+}
+
 func (merk *MerkleApp) Commit() tmsp.Result {
+	merk.saveVersion()
 	if merk.tree.Size() == 0 {
 		return tmsp.NewResultOK(nil, "Empty hash for empty tree")
 	}
@@ -90,10 +135,49 @@ func (merk *MerkleApp) Commit() tmsp.Result {
 	return tmsp.NewResultOK(hash, "")
 }
 
+func (merk *MerkleApp) saveVersion() {
+	// Copying an in-memory tree hashes it, which
+	// makes its nodes safe to share with the copy
+	merk.versions[merk.height] = merk.tree.Copy().(*merkle.IAVLTree)
+	merk.committed = merk.height
+	merk.prune()
+}
+
+func (merk *MerkleApp) prune() {
+	if merk.keepVersions == 0 {
+		return
+	}
+	for height := range merk.versions {
+		if height <= merk.committed-merk.keepVersions {
+			delete(merk.versions, height)
+		}
+	}
+}
+
 func (merk *MerkleApp) Query(query []byte) tmsp.Result {
 	if len(query) == 0 {
 		return tmsp.ErrEncodingError.SetLog("Query cannot be zero length")
 	}
+	if query[0] != QueryAtHeight {
+		return merk.queryTree(merk.tree, merk.committed, query)
+	}
+	height, n, err := wire.GetVarint(query[1:])
+	if err != nil {
+		return tmsp.ErrEncodingError.SetLog(Fmt("Error getting height: %v", err.Error()))
+	}
+	query = query[1+n:]
+	if len(query) == 0 || query[0] == QueryAtHeight {
+		return tmsp.ErrEncodingError.SetLog("Expected a tree query after height")
+	}
+	tree, ok := merk.versions[height]
+	if !ok {
+		return tmsp.NewResult(
+			ErrVersionNotFound, nil, Fmt("Error no state for height %v; it may have been pruned", height))
+	}
+	return merk.queryTree(tree, height, query)
+}
+
+func (merk *MerkleApp) queryTree(tree *merkle.IAVLTree, height int, query []byte) tmsp.Result {
 	queryType := query[0]
 	switch queryType {
 	case QueryValue:
@@ -106,7 +190,7 @@ func (merk *MerkleApp) Query(query []byte) tmsp.Result {
 		if len(query) != 0 {
 			return tmsp.ErrEncodingError.SetLog("Got bytes left over")
 		}
-		_, value, _ := merk.tree.Get(key)
+		_, value, _ := tree.Get(key)
 		if len(value) == 0 {
 			return tmsp.NewResult(
 				ErrValueNotFound, nil, Fmt("Error no value found for query: %v", query))
@@ -122,10 +206,10 @@ func (merk *MerkleApp) Query(query []byte) tmsp.Result {
 		if len(query) != 0 {
 			return tmsp.ErrEncodingError.SetLog(Fmt("Got bytes left over"))
 		}
-		key, _ := merk.tree.GetByIndex(index)
+		key, _ := tree.GetByIndex(index)
 		return tmsp.NewResultOK(key, "")
 	case QuerySize:
-		size := merk.tree.Size()
+		size := tree.Size()
 		data := wire.BinaryBytes(size)
 		return tmsp.NewResultOK(data, "")
 	case QueryProof:
@@ -138,10 +222,112 @@ func (merk *MerkleApp) Query(query []byte) tmsp.Result {
 		if len(query) != 0 {
 			return tmsp.ErrEncodingError.SetLog("Got bytes left over")
 		}
-		proof := merk.tree.ConstructProof(key)
+		proof := tree.ConstructProof(key)
+		if proof == nil {
+			return tmsp.NewResult(
+				ErrValueNotFound, nil, Fmt("Error no value found for key %X; try an absence proof", key))
+		}
 		data := wire.BinaryBytes(*proof)
 		return tmsp.NewResultOK(data, "")
+	case QueryAbsenceProof:
+		query = query[1:]
+		key, n, err := wire.GetByteSlice(query)
+		if err != nil {
+			return tmsp.ErrEncodingError.SetLog(Fmt("Error getting key: %v", err.Error()))
+		}
+		query = query[n:]
+		if len(query) != 0 {
+			return tmsp.ErrEncodingError.SetLog("Got bytes left over")
+		}
+		proof, ok := absenceProof(tree, key)
+		if !ok {
+			return tmsp.ErrBaseInvalidInput.SetLog(Fmt("Error key %X exists", key))
+		}
+		data := wire.BinaryBytes(proof)
+		return tmsp.NewResultOK(data, "")
+	case QueryRange:
+		query = query[1:]
+		start, n, err := wire.GetByteSlice(query)
+		if err != nil {
+			return tmsp.ErrEncodingError.SetLog(Fmt("Error getting start key: %v", err.Error()))
+		}
+		query = query[n:]
+		end, n, err := wire.GetByteSlice(query)
+		if err != nil {
+			return tmsp.ErrEncodingError.SetLog(Fmt("Error getting end key: %v", err.Error()))
+		}
+		query = query[n:]
+		limit, n, err := wire.GetVarint(query)
+		if err != nil {
+			return tmsp.ErrEncodingError.SetLog(Fmt("Error getting limit: %v", err.Error()))
+		}
+		query = query[n:]
+		if len(query) != 0 {
+			return tmsp.ErrEncodingError.SetLog("Got bytes left over")
+		}
+		if len(end) == 0 {
+			end = nil
+		}
+		if limit <= 0 || limit > MaxRangeLimit {
+			limit = MaxRangeLimit
+		}
+		kvz := rangeTree(tree, start, end, limit)
+		data := wire.BinaryBytes(kvz)
+		return tmsp.NewResultOK(data, "")
+	case QueryState:
+		if len(query) != 1 {
+			return tmsp.ErrEncodingError.SetLog("Got bytes left over")
+		}
+		// Hash and items are read together, so they match
+		snap := Snapshot{
+			Height: height,
+			Items:  rangeTree(tree, nil, nil, 0),
+		}
+		if tree.Size() > 0 {
+			snap.AppHash = tree.Hash()
+		}
+		data := wire.BinaryBytes(snap)
+		return tmsp.NewResultOK(data, "")
 	default:
 		return tmsp.ErrUnknownRequest.SetLog(Fmt("Unexpected Query type byte %X", queryType))
 	}
 }
+
+// For a missing key, Get returns the number of keys before it,
+// which is the index of the right neighbor
+
+func absenceProof(tree *merkle.IAVLTree, key []byte) (proof AbsenceProof, ok bool) {
+	index, _, exists := tree.Get(key)
+	if exists {
+		return proof, false
+	}
+	if index > 0 {
+		leftKey, _ := tree.GetByIndex(index - 1)
+		proof.Left = tree.ConstructProof(leftKey)
+	}
+	if index < tree.Size() {
+		rightKey, _ := tree.GetByIndex(index)
+		proof.Right = tree.ConstructProof(rightKey)
+	}
+	return proof, true
+}
+
+// Tree iteration is in ascending key order, so we can stop
+// as soon as we pass the end key. A limit of 0 means no limit.
+
+func (merk *MerkleApp) Range(start, end []byte, limit int) []KVPair {
+	return rangeTree(merk.tree, start, end, limit)
+}
+
+func rangeTree(tree *merkle.IAVLTree, start, end []byte, limit int) (kvz []KVPair) {
+	tree.Iterate(func(key, value []byte) bool {
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			return true
+		}
+		if bytes.Compare(key, start) >= 0 {
+			kvz = append(kvz, KVPair{key, value})
+		}
+		return limit > 0 && len(kvz) == limit
+	})
+	return kvz
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tendermint/go-wire"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"testing"
+)
+
+func TestQueryAtHeight(t *testing.T) {
+	cli := NewLocalClient()
+	key := []byte("base/a/someone")
+
+	for height, value := range []string{"", "first", "second", "third"} {
+		if height == 0 {
+			continue
+		}
+		cli.BeginBlockSync(uint64(height))
+		cli.SetSync(key, []byte(value))
+		cli.CommitSync()
+	}
+
+	query := KeyQuery(key, QueryValue)
+	res := cli.QuerySync(query)
+	if !bytes.Equal(res.Data, []byte("third")) {
+		t.Errorf("Expected latest value third, got %q", res.Data)
+	}
+	res = cli.QuerySync(HeightQuery(1, query))
+	if !bytes.Equal(res.Data, []byte("first")) {
+		t.Errorf("Expected value first at height 1, got %q", res.Data)
+	}
+
+	// Keep heights 2 and 3
+	res = cli.SetOptionSync("keep_versions", "2")
+	if res.Log != "Success" {
+		t.Fatal(res.Log)
+	}
+	res = cli.QuerySync(HeightQuery(1, query))
+	if res.Code != ErrVersionNotFound {
+		t.Errorf("Expected height 1 to be pruned, got %v", res)
+	}
+	res = cli.QuerySync(HeightQuery(2, query))
+	if !bytes.Equal(res.Data, []byte("second")) {
+		t.Errorf("Expected value second at height 2, got %q", res.Data)
+	}
+}
+
+func TestSetKeepVersions(t *testing.T) {
+	app := NewApp(NewLocalClient())
+	err := app.SetKeepVersions(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.SetKeepVersions(-1) == nil {
+		t.Error("Expected negative keep versions to be rejected")
+	}
+
+	key := []byte("base/a/someone")
+	for height, value := range []string{"first", "second"} {
+		app.cli.BeginBlockSync(uint64(height + 1))
+		app.cli.SetSync(key, []byte(value))
+		app.cli.CommitSync()
+	}
+	res := app.Query(HeightQuery(1, KeyQuery(key, QueryValue)))
+	if res.Code != ErrVersionNotFound {
+		t.Errorf("Expected height 1 to be pruned, got %v", res)
+	}
+}
+
+func TestAbsenceProof(t *testing.T) {
+	cli := NewLocalClient()
+
+	// Empty tree
+	checkAbsent(t, cli, []byte("b"), nil)
+
+	for _, key := range []string{"b", "d", "f", "h", "j"} {
+		cli.SetSync([]byte(key), []byte("value "+key))
+	}
+	hash := cli.CommitSync().Data
+
+	// Before, between and after existing keys
+	for _, key := range []string{"a", "c", "e", "g", "i", "k"} {
+		checkAbsent(t, cli, []byte(key), hash)
+	}
+
+	res := cli.QuerySync(KeyQuery([]byte("d"), QueryAbsenceProof))
+	if res.IsOK() {
+		t.Error("Expected no absence proof for an existing key")
+	}
+
+	// Neighbors that are not adjacent
+	var proof AbsenceProof
+	res = cli.QuerySync(KeyQuery([]byte("c"), QueryAbsenceProof))
+	wire.ReadBinaryBytes(res.Data, &proof)
+	other := proof
+	res = cli.QuerySync(KeyQuery([]byte("g"), QueryAbsenceProof))
+	wire.ReadBinaryBytes(res.Data, &proof)
+	other.Right = proof.Right
+	if other.Verify([]byte("e"), hash) == nil {
+		t.Error("Expected proof with non-adjacent neighbors to fail")
+	}
+}
+
+func checkAbsent(t *testing.T, cli *Client, key, hash []byte) {
+	res := cli.QuerySync(KeyQuery(key, QueryAbsenceProof))
+	if res.IsErr() {
+		t.Fatalf("%s: %v", key, res.Error())
+	}
+	var proof AbsenceProof
+	err := wire.ReadBinaryBytes(res.Data, &proof)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	err = proof.Verify(key, hash)
+	if err != nil {
+		t.Errorf("%s: %v", key, err)
+	}
+}
+
+func TestRangeQueryLimit(t *testing.T) {
+	cli := NewLocalClient()
+	for i := 0; i < MaxRangeLimit+10; i++ {
+		cli.SetSync([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+	}
+	cli.CommitSync()
+
+	cases := []struct {
+		start, end []byte
+		limit      int
+		count      int
+	}{
+		{[]byte("key"), nil, 5, 5},
+		{[]byte("key00003"), []byte("key00005"), 5, 2},
+		{nil, nil, 0, MaxRangeLimit},
+		{nil, nil, MaxRangeLimit + 5, MaxRangeLimit},
+	}
+	for _, c := range cases {
+		res := cli.RangeSync(c.start, c.end, c.limit)
+		if res.IsErr() {
+			t.Fatal(res.Error())
+		}
+		var kvz []KVPair
+		err := wire.ReadBinaryBytes(res.Data, &kvz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kvz) != c.count {
+			t.Errorf("[%s, %s) limit %d: expected %d pairs, got %d",
+				c.start, c.end, c.limit, c.count, len(kvz))
+		}
+		if len(kvz) > 0 && bytes.Compare(kvz[0].Key, c.start) < 0 {
+			t.Errorf("[%s, %s): first key %s before start", c.start, c.end, kvz[0].Key)
+		}
+	}
+}
+
+func TestClientIterate(t *testing.T) {
+	cli := NewLocalClient()
+	total := 2*MaxRangeLimit + 3
+	for i := 0; i < total; i++ {
+		cli.SetSync([]byte(fmt.Sprintf("key%05d", i)), []byte("value"))
+	}
+	cli.SetSync([]byte("other"), []byte("value"))
+	cli.CommitSync()
+
+	// Pages through the whole range
+	var keys []string
+	stopped, err := cli.Iterate([]byte("key"), PrefixEnd([]byte("key")), func(key, _ []byte) bool {
+		keys = append(keys, string(key))
+		return false
+	})
+	if stopped || err != nil {
+		t.Fatalf("Expected full iteration, got stopped %v, err %v", stopped, err)
+	}
+	if len(keys) != total {
+		t.Fatalf("Expected %d keys, got %d", total, len(keys))
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key%05d", i) {
+			t.Fatalf("Expected key%05d at %d, got %s", i, i, key)
+		}
+	}
+
+	// Stops in a later page
+	var count int
+	stopped, err = cli.Iterate(nil, nil, func(_, _ []byte) bool {
+		count++
+		return count == MaxRangeLimit+1
+	})
+	if !stopped || err != nil || count != MaxRangeLimit+1 {
+		t.Errorf("Expected stop after %d keys, got %d (err %v)", MaxRangeLimit+1, count, err)
+	}
+}
+
+func TestDefaultKeepVersions(t *testing.T) {
+	cli := NewLocalClient()
+	key := []byte("base/a/someone")
+	for height := 1; height <= DefaultKeepVersions+1; height++ {
+		cli.BeginBlockSync(uint64(height))
+		cli.SetSync(key, []byte(fmt.Sprintf("value %d", height)))
+		cli.CommitSync()
+	}
+	query := KeyQuery(key, QueryValue)
+	res := cli.QuerySync(HeightQuery(1, query))
+	if res.Code != ErrVersionNotFound {
+		t.Errorf("Expected height 1 to be pruned by default, got %v", res)
+	}
+	res = cli.QuerySync(HeightQuery(2, query))
+	if !bytes.Equal(res.Data, []byte("value 2")) {
+		t.Errorf("Expected value 2 at height 2, got %q", res.Data)
+	}
+
+	// Zero keeps every version from then on
+	res = cli.SetOptionSync("keep_versions", "0")
+	if res.Log != "Success" {
+		t.Fatal(res.Log)
+	}
+	for height := DefaultKeepVersions + 2; height <= DefaultKeepVersions+3; height++ {
+		cli.BeginBlockSync(uint64(height))
+		cli.CommitSync()
+	}
+	res = cli.QuerySync(HeightQuery(2, query))
+	if !bytes.Equal(res.Data, []byte("value 2")) {
+		t.Errorf("Expected height 2 to be kept, got %q", res.Data)
+	}
+}
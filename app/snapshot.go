@@ -0,0 +1,215 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-merkle"
+	"github.com/tendermint/go-wire"
+	sm "github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io/ioutil"
+	"strings"
+)
+
+// State snapshots move a chain's committed state to a new chain,
+// e.g. for a migration or a fork. The new chain starts at height 1;
+// Height in the snapshot records where the state was taken, and
+// state recorded at heights is rebased onto the new chain.
+//
+// Issue filters are in memory and not in the tree, so the
+// snapshot lists the forms in each filter to rebuild them.
+
+// Zero height exports the latest committed state
+
+func (app *App) exportState(height int) (*Snapshot, error) {
+	query := EmptyQuery(QueryState)
+	if height > 0 {
+		query = HeightQuery(height, query)
+	}
+	res := app.cli.QuerySync(query)
+	if res.IsErr() {
+		return nil, errors.New(res.Error())
+	}
+	snap := &Snapshot{}
+	err := wire.ReadBinaryBytes(res.Data, snap)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding tree state")
+	}
+	snap.Version = SnapshotVersion
+	snap.ChainID = app.state.GetChainID()
+	snap.Issues = app.Issues
+	snap.Filters = app.exportFilters(snap.Items)
+	return snap, nil
+}
+
+// Filters can't be listed and lookups can give false positives,
+// so memberships come from the issue stored with each form.
+// Forms submitted before issues were stored are left out.
+
+func (app *App) exportFilters(items []KVPair) []IssueFilter {
+	prefix := []byte(sm.FormIssuePrefix)
+	formIDs := make(map[string][][]byte)
+	for _, item := range items {
+		if !bytes.HasPrefix(item.Key, prefix) {
+			continue
+		}
+		issue := string(item.Value)
+		formIDs[issue] = append(formIDs[issue], item.Key[len(prefix):])
+	}
+	var filters []IssueFilter
+	for _, issue := range app.Issues {
+		filters = append(filters, IssueFilter{issue, formIDs[issue]})
+	}
+	return filters
+}
+
+// ExportState reads all committed state at
+// a height, or the latest, from a running node
+
+func ExportState(proxy *Proxy, height int) (*Snapshot, error) {
+	query := EmptyQuery(QueryState)
+	if height > 0 {
+		query = HeightQuery(height, query)
+	}
+	data, err := queryData(proxy, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying state")
+	}
+	snap := &Snapshot{}
+	err = wire.ReadBinaryBytes(data, snap)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding state")
+	}
+	return snap, nil
+}
+
+// ImportState checks the snapshot and sets the app state kept
+// outside the tree. The tree is loaded at InitChain. The new
+// chain must have a different chainID, so txs signed for the
+// old chain cannot be replayed on it.
+
+func (app *App) ImportState(snap *Snapshot, chainID string) error {
+	err := VerifySnapshot(snap)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(chainID) == "" || chainID == snap.ChainID {
+		return errors.Errorf("Imported state needs a chain ID other than %q", snap.ChainID)
+	}
+	app.state.SetChainID(chainID)
+	app.Issues = append(app.Issues, snap.Issues...)
+	app.snapshot = snap
+	app.resetCaches()
+	return nil
+}
+
+func (app *App) loadSnapshot() error {
+	for _, item := range app.snapshot.Items {
+		app.state.Set(item.Key, item.Value)
+	}
+	res := app.cli.CommitSync()
+	if res.IsErr() {
+		return errors.New(res.Error())
+	}
+	if !bytes.Equal(res.Data, app.snapshot.AppHash) {
+		return errors.Errorf("Imported root %X does not match exported root %X",
+			res.Data, app.snapshot.AppHash)
+	}
+	for _, filter := range app.snapshot.Filters {
+		for _, formID := range filter.FormIDs {
+			err := app.state.FilterAdd(formID, filter.Issue)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := app.state.RebaseHeights(app.snapshot.Height)
+	if err != nil {
+		return errors.Wrap(err, "rebasing heights")
+	}
+	app.snapshot = nil
+	app.resetCaches()
+	return nil
+}
+
+// VerifySnapshot rebuilds the tree from the snapshot
+// items and checks its root against the app hash
+
+func VerifySnapshot(snap *Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return errors.Errorf("Unsupported snapshot version %v; expected %v",
+			snap.Version, SnapshotVersion)
+	}
+	if strings.TrimSpace(snap.ChainID) == "" {
+		return errors.New("Snapshot has no chain_id")
+	}
+	issues := make(map[string]bool)
+	for _, issue := range snap.Issues {
+		issues[issue] = true
+	}
+	tree := merkle.NewIAVLTree(0, nil)
+	for i, item := range snap.Items {
+		if len(item.Value) == 0 {
+			return errors.Errorf("items[%d]: empty value for key %X", i, item.Key)
+		}
+		if tree.Set(item.Key, item.Value) {
+			return errors.Errorf("items[%d]: duplicate key %X", i, item.Key)
+		}
+	}
+	var hash []byte
+	if tree.Size() > 0 {
+		hash = tree.Hash()
+	}
+	if !bytes.Equal(hash, snap.AppHash) {
+		return errors.Errorf("Snapshot root %X does not match app hash %X", hash, snap.AppHash)
+	}
+	for i, filter := range snap.Filters {
+		if !issues[filter.Issue] {
+			return errors.Errorf("filters[%d]: unknown issue %q", i, filter.Issue)
+		}
+		for _, formID := range filter.FormIDs {
+			_, value, _ := tree.Get(sm.FormIssueKey(formID))
+			if string(value) != filter.Issue {
+				return errors.Errorf("filters[%d]: form %X is not a %q form", i, formID, filter.Issue)
+			}
+		}
+	}
+	return nil
+}
+
+// Snapshot files ending in .json are written as JSON,
+// any others in the binary wire format
+
+func WriteSnapshot(filePath string, snap *Snapshot) error {
+	var data []byte
+	if strings.HasSuffix(filePath, ".json") {
+		var err error
+		data, err = json.MarshalIndent(snap, "", "\t")
+		if err != nil {
+			return errors.Wrap(err, "encoding snapshot")
+		}
+	} else {
+		data = wire.BinaryBytes(*snap)
+	}
+	return WriteFile(filePath, data, 0644)
+}
+
+func LoadSnapshot(filePath string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading snapshot file")
+	}
+	snap := &Snapshot{}
+	if strings.HasSuffix(filePath, ".json") {
+		err = json.Unmarshal(data, snap)
+	} else {
+		err = wire.ReadBinaryBytes(data, snap)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding snapshot file %s", filePath)
+	}
+	return snap, nil
+}
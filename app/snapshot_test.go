@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	sm "github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"gx/ipfs/QmcEcrBAMrwMyhSjXt4yfyPpzgSuV8HLHavnfmiKCSRqZU/go-cid"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportImportState(t *testing.T) {
+	defer func(max int) { sm.MaxSupportedVersion = max }(sm.MaxSupportedVersion)
+	sm.MaxSupportedVersion = 2
+
+	app := NewApp(NewLocalClient())
+	gen, err := ParseGenesis([]byte(`{
+		"chain_id": "` + chainID + `",
+		"issues": ["pothole"],
+		"accounts": [{"pub_key": "` + testPubKey + `", "username": "someone"}],
+		"upgrades": [{"height": 10, "version": 2}],
+		"genesis_time": "` + time.Now().UTC().Format(time.RFC3339) + `"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.ApplyGenesis(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := app.Commit().Data
+
+	res := app.Query(EmptyQuery(QueryState))
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	snap := &Snapshot{}
+	err = wire.ReadBinaryBytes(res.Data, snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(snap.AppHash, hash) {
+		t.Fatalf("Expected app hash %X, got %X", hash, snap.AppHash)
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"state.json", "state.bin"} {
+		filePath := filepath.Join(dir, name)
+		err = WriteSnapshot(filePath, snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadSnapshot(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported := NewApp(NewLocalClient())
+		err = imported.ImportState(loaded, "new_chain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported.InitChain([]*tmsp.Validator{})
+		if got := imported.Commit().Data; !bytes.Equal(got, hash) {
+			t.Errorf("%v: expected root %X after import, got %X", name, hash, got)
+		}
+	}
+
+	// Tampered snapshot is rejected before import
+	snap.Items[0].Value = []byte("tampered")
+	err = NewApp(NewLocalClient()).ImportState(snap, "new_chain")
+	if err == nil {
+		t.Error("Expected tampered snapshot to be rejected")
+	}
+}
+
+func searchIssue(t *testing.T, app *App, issue string) [][]byte {
+	res := app.Query(KeyQuery(wire.BinaryBytes(Search{Issue: issue}), QuerySearch))
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	var formIDs [][]byte
+	if len(res.Data) > 0 {
+		err := wire.ReadBinaryBytes(res.Data, &formIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return formIDs
+}
+
+func TestImportStateSearch(t *testing.T) {
+	app := NewApp(NewLocalClient())
+	defer func(max int) { sm.MaxSupportedVersion = max }(sm.MaxSupportedVersion)
+	sm.MaxSupportedVersion = 2
+
+	gen, err := ParseGenesis([]byte(`{
+		"chain_id": "` + chainID + `",
+		"issues": ["pothole", "graffiti"],
+		"upgrades": [{"height": 10, "version": 2}],
+		"genesis_time": "` + time.Now().UTC().Format(time.RFC3339) + `"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.ApplyGenesis(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetFilters()
+	app.InitChain([]*tmsp.Validator{})
+
+	privKey := crypto.GenPrivKeyEd25519()
+	appendTx := func(action Action, seq int) {
+		action.Prepare(privKey.PubKey(), seq)
+		action.Sign(privKey, chainID)
+		res := app.AppendTx(action.Tx())
+		if res.IsErr() {
+			t.Fatal(res.Error())
+		}
+	}
+	contentID, err := cid.Decode("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	form := Form{Issue: "pothole", Location: "somewhere", SubmittedAt: now.UTC().String()}
+	data, err := json.Marshal(NewInfo(contentID, form, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.BeginBlock(1)
+	appendTx(NewAction(ActionCreateAccount, wire.BinaryBytes([]byte("someone"))), 1)
+	appendTx(NewAction(ActionSubmitForm, data), 2)
+	app.EndBlock(1)
+	app.Commit()
+	if formIDs := searchIssue(t, app, "pothole"); len(formIDs) != 1 {
+		t.Fatalf("Expected 1 form before export, got %d", len(formIDs))
+	}
+
+	snap, err := app.exportState(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sameChainID := range []string{"", chainID} {
+		if NewApp(NewLocalClient()).ImportState(snap, sameChainID) == nil {
+			t.Errorf("Expected import with chain ID %q to be rejected", sameChainID)
+		}
+	}
+	imported := NewApp(NewLocalClient())
+	err = imported.ImportState(snap, "new_chain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported.SetFilters()
+	imported.InitChain([]*tmsp.Validator{})
+
+	// Heights are rebased onto the new chain
+	if meta := imported.state.GetFormMeta(form.ID()); meta == nil || meta.Height != 0 {
+		t.Errorf("Expected imported form at height 0, got %v", meta)
+	}
+	if upgrades := imported.state.GetUpgrades(); len(upgrades) != 1 || upgrades[0].Height != 9 {
+		t.Errorf("Expected upgrade rebased to height 9, got %v", upgrades)
+	}
+
+	formIDs := searchIssue(t, imported, "pothole")
+	if len(formIDs) != 1 || !bytes.Equal(formIDs[0], form.ID()) {
+		t.Errorf("Expected form %X in search after import, got %X", form.ID(), formIDs)
+	}
+	if formIDs := searchIssue(t, imported, "graffiti"); len(formIDs) != 0 {
+		t.Errorf("Expected no graffiti forms after import, got %X", formIDs)
+	}
+
+	// Filters must name forms of their issue in the snapshot
+	snap.Filters[0].FormIDs = append(snap.Filters[0].FormIDs, make([]byte, FORM_ID_LENGTH))
+	err = NewApp(NewLocalClient()).ImportState(snap, "new_chain")
+	if err == nil {
+		t.Error("Expected snapshot with an unknown filter form to be rejected")
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"github.com/tendermint/go-crypto"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Package client is a Go client for comit. HTTPClient talks to
+// a manager's /api/v1; NodeClient talks to a node through a
+// Proxy, for a node the caller trusts, and stores form content
+// itself. Both sign actions locally with the caller's key.
+
+type Client interface {
+	ChainID() (string, error)
+
+	// The account for the client's key
+	CreateAccount(username string) (*NewAccount, error)
+	RemoveAccount() error
+
+	SubmitForm(req *FormRequest) (*Idpair, error)
+
+	// Zero height reads the latest state
+	FindForm(formID string, height int) (*Form, error)
+	FindAccount(pubKey crypto.PubKey, height int) (*Account, error)
+
+	// Form IDs in hex
+	SearchForms(search Search) ([]string, error)
+
+	// Forms for an issue, and receipts for the
+	// client's forms once they are committed
+	Subscribe(issue string) (*Feed, error)
+}
+
+type FormRequest struct {
+	Issue       string
+	Location    string
+	Description string
+
+	ContentType string
+	Media       io.Reader
+}
+
+// Number of blocks an action signed
+// by the client stays valid for
+const ValidBlocks = 100
+
+// Feed of updates; Close stops it and closes Updates
+
+type Feed struct {
+	Updates <-chan *Update
+	close   func() error
+}
+
+func (feed *Feed) Close() error {
+	return feed.close()
+}
+
+func newForm(req *FormRequest, submitter crypto.PubKey, submittedAt time.Time) (Form, error) {
+	form := Form{
+		ContentType: req.ContentType,
+		Description: req.Description,
+		Issue:       req.Issue,
+		Location:    req.Location,
+		SubmittedAt: FormatTime(submittedAt),
+		Submitter:   PubKeytoHexstr(submitter),
+	}
+	if req.Media == nil {
+		return form, nil
+	}
+	data, err := ioutil.ReadAll(req.Media)
+	if err != nil {
+		return Form{}, err
+	}
+	form.Data = data
+	return form, nil
+}
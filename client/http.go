@@ -0,0 +1,400 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HTTPClient uses a manager's /api/v1. The manager prepares
+// actions and the client signs them with its key, unless the
+// client logged in with a password and the manager holds the key.
+
+type HTTPClient struct {
+	base    string
+	http    *http.Client
+	privKey crypto.PrivKey
+	chainID string
+}
+
+// A nil key is for clients that log in with a password
+func NewHTTPClient(base string, privKey crypto.PrivKey) *HTTPClient {
+	jar, _ := cookiejar.New(nil)
+	return &HTTPClient{
+		base:    strings.TrimRight(base, "/"),
+		http:    &http.Client{Jar: jar},
+		privKey: privKey,
+	}
+}
+
+type apiResult struct {
+	Action string          `json:"action"`
+	Data   json.RawMessage `json:"data"`
+	Error  *APIError       `json:"error"`
+}
+
+// Pins the chain ID actions are signed for, instead
+// of the one the manager reports
+
+func (c *HTTPClient) SetChainID(chainID string) {
+	c.chainID = chainID
+}
+
+// Decodes the response data into v. Actions the manager
+// returns for signing are checked with expect, then signed
+// and broadcast; a nil expect refuses to sign anything.
+
+func (c *HTTPClient) do(req *http.Request, expect expectFunc, v interface{}) error {
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var result apiResult
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return errors.Wrapf(err, "decoding response (status %v)", res.StatusCode)
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if strings.HasPrefix(result.Action, "sign_") {
+		return c.signAndBroadcast(result.Data, expect, v)
+	}
+	if v == nil || len(result.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(result.Data, v)
+}
+
+func (c *HTTPClient) get(path string, vals url.Values, v interface{}) error {
+	req, err := http.NewRequest("GET", c.base+APIPrefix+path+"?"+vals.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil, v)
+}
+
+func (c *HTTPClient) post(path string, fields map[string]interface{}, v interface{}) error {
+	return c.postAction(path, fields, nil, v)
+}
+
+func (c *HTTPClient) postAction(path string, fields map[string]interface{}, expect expectFunc, v interface{}) error {
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.base+APIPrefix+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, expect, v)
+}
+
+// Checks the action the manager prepared is the one the caller asked for
+type expectFunc func(action Action) error
+
+func expectAction(actionType byte, data []byte) expectFunc {
+	return func(action Action) error {
+		if action.Type != actionType || !bytes.Equal(action.Data, data) {
+			return errors.New("Action to sign is not the one requested")
+		}
+		return nil
+	}
+}
+
+// The manager's sign bytes are never signed. The action is
+// rebuilt with the client's key and chain ID, checked, and its
+// sign bytes computed here; the ID must match the manager's so
+// it broadcasts the same action.
+
+func (c *HTTPClient) signAndBroadcast(data json.RawMessage, expect expectFunc, v interface{}) error {
+	if c.privKey == nil {
+		return errors.New("Action needs a signature and the client has no key")
+	}
+	if expect == nil {
+		return errors.New("Manager returned an action to sign for a request that has none")
+	}
+	var unsigned struct {
+		ID     string `json:"id"`
+		Action struct {
+			Type  byte
+			Input struct {
+				Address    []byte
+				Sequence   int
+				ValidUntil int
+			}
+			Data []byte
+		} `json:"action"`
+	}
+	err := json.Unmarshal(data, &unsigned)
+	if err != nil {
+		return errors.Wrap(err, "decoding action to sign")
+	}
+	pubKey := c.privKey.PubKey()
+	input := unsigned.Action.Input
+	if !bytes.Equal(input.Address, pubKey.Address()) {
+		return errors.New("Action to sign is not for the client's key")
+	}
+	action := NewAction(unsigned.Action.Type, unsigned.Action.Data)
+	action.Prepare(pubKey, input.Sequence)
+	action.SetValidUntil(input.ValidUntil)
+	err = expect(action)
+	if err != nil {
+		return err
+	}
+	chainID, err := c.ChainID()
+	if err != nil {
+		return err
+	}
+	if unsigned.ID != BytesToHexstr(action.ID(chainID)) {
+		return errors.New("Action to sign does not match the manager's action ID")
+	}
+	sig := c.privKey.Sign(action.SignBytes(chainID))
+	return c.post("/broadcast", map[string]interface{}{
+		"id":        unsigned.ID,
+		"signature": SignaturetoHexstr(sig),
+	}, v)
+}
+
+func (c *HTTPClient) ChainID() (string, error) {
+	if c.chainID != "" {
+		return c.chainID, nil
+	}
+	err := c.get("/chain_id", nil, &c.chainID)
+	return c.chainID, err
+}
+
+func (c *HTTPClient) Issues() ([]string, error) {
+	var issues []string
+	err := c.get("/issues", nil, &issues)
+	return issues, err
+}
+
+// Login signs a challenge from the manager with the client's key
+func (c *HTTPClient) Login() error {
+	if c.privKey == nil {
+		return errors.New("Client has no key; log in with a password")
+	}
+	chainID, err := c.ChainID()
+	if err != nil {
+		return err
+	}
+	pubKeystr := PubKeytoHexstr(c.privKey.PubKey())
+	var challenge string
+	err = c.post("/login_challenge", map[string]interface{}{"pub_key": pubKeystr}, &challenge)
+	if err != nil {
+		return err
+	}
+	sig := c.privKey.Sign(LoginSignBytes(chainID, HexstrToBytes(challenge)))
+	return c.post("/login", map[string]interface{}{
+		"pub_key":   pubKeystr,
+		"challenge": challenge,
+		"signature": SignaturetoHexstr(sig),
+	}, nil)
+}
+
+// Logs in with a key the manager keeps in its keystore
+func (c *HTTPClient) LoginPassword(username, password string) error {
+	return c.post("/login", map[string]interface{}{
+		"username": username,
+		"password": password,
+	}, nil)
+}
+
+func (c *HTTPClient) Logout() error {
+	return c.post("/logout", nil, nil)
+}
+
+func (c *HTTPClient) CreateAccount(username string) (*NewAccount, error) {
+	if c.privKey == nil {
+		return nil, errors.New("Client has no key; use CreateAccountPassword")
+	}
+	newAcc := &NewAccount{}
+	expect := expectAction(ActionCreateAccount, wire.BinaryBytes([]byte(username)))
+	err := c.postAction("/create_account", map[string]interface{}{
+		"pub_key":  PubKeytoHexstr(c.privKey.PubKey()),
+		"username": username,
+	}, expect, newAcc)
+	return newAcc, err
+}
+
+// The manager generates the key and keeps it encrypted with the
+// password. The result has the key's recovery phrase, only once.
+
+func (c *HTTPClient) CreateAccountPassword(username, password, keyType string) (*NewAccount, error) {
+	newAcc := &NewAccount{}
+	err := c.post("/create_account", map[string]interface{}{
+		"username": username,
+		"password": password,
+		"key_type": keyType,
+	}, newAcc)
+	return newAcc, err
+}
+
+func (c *HTTPClient) RemoveAccount() error {
+	return c.postAction("/remove_account", nil, expectAction(ActionRemoveAccount, nil), nil)
+}
+
+func (c *HTTPClient) SubmitForm(form *FormRequest) (*Idpair, error) {
+	if form.Media == nil {
+		return nil, errors.New("Form needs media")
+	}
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	mw.WriteField("issue", form.Issue)
+	mw.WriteField("location", form.Location)
+	mw.WriteField("description", form.Description)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="media"; filename="media"`)
+	header.Set("Content-Type", form.ContentType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(part, form.Media)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading media")
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.base+APIPrefix+"/submit_form", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set(CSRFHeader, "XMLHttpRequest")
+	idpair := &Idpair{}
+	err = c.do(req, c.expectForm(form), idpair)
+	return idpair, err
+}
+
+// The manager stores the form content and sets the submission
+// time, so only the form fields the client sent are checked
+
+func (c *HTTPClient) expectForm(form *FormRequest) expectFunc {
+	return func(action Action) error {
+		var info Info
+		if action.Type != ActionSubmitForm || json.Unmarshal(action.Data, &info) != nil ||
+			info.Issue != form.Issue || info.Location != form.Location ||
+			!PubKeyHexstrEqual(info.Submitter, c.privKey.PubKey()) {
+			return errors.New("Action to sign is not the form requested")
+		}
+		return nil
+	}
+}
+
+func heightValues(height int) url.Values {
+	vals := make(url.Values)
+	if height > 0 {
+		vals.Set("height", strconv.Itoa(height))
+	}
+	return vals
+}
+
+func (c *HTTPClient) FindForm(formID string, height int) (*Form, error) {
+	vals := heightValues(height)
+	vals.Set("form_id", formID)
+	form := &Form{}
+	err := c.get("/find_form", vals, form)
+	return form, err
+}
+
+// The public key is left out when decoding, since it is
+// an interface; it is the key the account was found by
+
+func (c *HTTPClient) FindAccount(pubKey crypto.PubKey, height int) (*Account, error) {
+	vals := heightValues(height)
+	vals.Set("pub_key", PubKeytoHexstr(pubKey))
+	var acc struct {
+		Account
+		PubKey json.RawMessage `json:"pub_key"`
+	}
+	err := c.get("/find_account", vals, &acc)
+	if err != nil {
+		return nil, err
+	}
+	acc.Account.PubKey = pubKey
+	return &acc.Account, nil
+}
+
+func (c *HTTPClient) SearchForms(search Search) ([]string, error) {
+	vals := make(url.Values)
+	vals.Set("issue", search.Issue)
+	if !search.After.IsZero() {
+		vals.Set("after", FormatTime(search.After))
+	}
+	if !search.Before.IsZero() {
+		vals.Set("before", FormatTime(search.Before))
+	}
+	var formIDs []string
+	err := c.get("/search_forms", vals, &formIDs)
+	return formIDs, err
+}
+
+// Subscribe needs a login; the manager sends
+// receipts for the logged in account's forms
+
+func (c *HTTPClient) Subscribe(issue string) (*Feed, error) {
+	u, err := url.Parse(c.base + APIPrefix + "/updates")
+	if err != nil {
+		return nil, err
+	}
+	header := make(http.Header)
+	for _, cookie := range c.http.Jar.Cookies(u) {
+		header.Add("Cookie", cookie.String())
+	}
+	wsURL := *u
+	wsURL.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL.String(), header)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to updates")
+	}
+	err = ws.WriteMessage(websocket.TextMessage, []byte(issue))
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	updates := make(chan *Update)
+	done := make(chan struct{})
+	go func() {
+		defer close(updates)
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			update := &Update{}
+			if json.Unmarshal(data, update) != nil {
+				// e.g. "Not logged in"
+				update = &Update{Type: "error", Error: string(data)}
+			}
+			select {
+			case updates <- update:
+			case <-done:
+				return
+			}
+		}
+	}()
+	closeFeed := func() error {
+		close(done)
+		return ws.Close()
+	}
+	return &Feed{Updates: updates, close: closeFeed}, nil
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// A manager that asks for signatures on login and account
+// creation and checks them against the client's key. The
+// returned func makes it prepare a different action than
+// the one requested.
+
+func fakeManager(t *testing.T, pubKey crypto.PubKey) (*httptest.Server, func(Action)) {
+	const chainID = "test_chain"
+	challenge := []byte("challenge")
+	var prepared Action
+	var swap *Action
+
+	respond := func(w http.ResponseWriter, res *APIResponse) {
+		status := http.StatusOK
+		if res.Error != nil {
+			status = ErrCodeToStatus(res.Error.Code)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	}
+	fields := func(req *http.Request) map[string]string {
+		var f map[string]string
+		json.NewDecoder(req.Body).Decode(&f)
+		return f
+	}
+	checkSig := func(w http.ResponseWriter, msg []byte, sigstr string) bool {
+		sig, err := SignaturefromHexstr(sigstr)
+		if err != nil || !pubKey.VerifyBytes(msg, sig) {
+			respond(w, &APIResponse{Error: NewAPIError(ErrCodeInvalidSignature, "Invalid signature")})
+			return false
+		}
+		return true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"/chain_id", func(w http.ResponseWriter, req *http.Request) {
+		respond(w, &APIResponse{Data: chainID})
+	})
+	mux.HandleFunc(APIPrefix+"/login_challenge", func(w http.ResponseWriter, req *http.Request) {
+		respond(w, &APIResponse{Data: BytesToHexstr(challenge)})
+	})
+	mux.HandleFunc(APIPrefix+"/login", func(w http.ResponseWriter, req *http.Request) {
+		f := fields(req)
+		if checkSig(w, LoginSignBytes(chainID, HexstrToBytes(f["challenge"])), f["signature"]) {
+			respond(w, &APIResponse{})
+		}
+	})
+	mux.HandleFunc(APIPrefix+"/create_account", func(w http.ResponseWriter, req *http.Request) {
+		f := fields(req)
+		if f["pub_key"] != PubKeytoHexstr(pubKey) {
+			t.Error("Expected the client's public key")
+		}
+		prepared = NewAction(ActionCreateAccount, wire.BinaryBytes([]byte(f["username"])))
+		prepared.Prepare(pubKey, 1)
+		unsigned := NewUnsignedAction(prepared, chainID)
+		if swap != nil {
+			// Shows the requested action, but the ID and
+			// sign bytes are for the swapped one
+			prepared = *swap
+			other := NewUnsignedAction(prepared, chainID)
+			unsigned.ID, unsigned.SignBytes = other.ID, other.SignBytes
+		}
+		respond(w, &APIResponse{Action: "sign_create_account", Data: unsigned})
+	})
+	mux.HandleFunc(APIPrefix+"/broadcast", func(w http.ResponseWriter, req *http.Request) {
+		f := fields(req)
+		if f["id"] != BytesToHexstr(prepared.ID(chainID)) {
+			respond(w, &APIResponse{Error: NewAPIError(ErrCodeBadRequest, "Unknown or expired action")})
+			return
+		}
+		if swap != nil {
+			t.Error("Client signed an action it did not request")
+		}
+		if checkSig(w, prepared.SignBytes(chainID), f["signature"]) {
+			respond(w, &APIResponse{Data: &NewAccount{PubKeystr: PubKeytoHexstr(pubKey)}})
+		}
+	})
+	return httptest.NewServer(mux), func(action Action) { swap = &action }
+}
+
+func TestHTTPClient(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519()
+	server, _ := fakeManager(t, privKey.PubKey())
+	defer server.Close()
+
+	c := NewHTTPClient(server.URL, privKey)
+	if err := c.Login(); err != nil {
+		t.Fatal(err)
+	}
+	newAcc, err := c.CreateAccount("someone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newAcc.PubKeystr != PubKeytoHexstr(privKey.PubKey()) {
+		t.Errorf("Unexpected account %+v", newAcc)
+	}
+
+	// Signed with another key
+	other := NewHTTPClient(server.URL, crypto.GenPrivKeyEd25519())
+	err = other.Login()
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.Code != ErrCodeInvalidSignature {
+		t.Errorf("Expected invalid signature, got %v", err)
+	}
+
+	// No key to sign with
+	if _, err = NewHTTPClient(server.URL, nil).CreateAccount("someone"); err == nil {
+		t.Error("Expected error without a key")
+	}
+}
+
+func TestHTTPClientChecksAction(t *testing.T) {
+	privKey := crypto.GenPrivKeyEd25519()
+	server, swap := fakeManager(t, privKey.PubKey())
+	defer server.Close()
+	c := NewHTTPClient(server.URL, privKey)
+
+	remove := NewAction(ActionRemoveAccount, nil)
+	remove.Prepare(privKey.PubKey(), 2)
+	swap(remove)
+	if _, err := c.CreateAccount("someone"); err == nil {
+		t.Error("Expected client to refuse sign bytes for another action")
+	}
+
+	// Same action for another chain
+	create := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte("someone")))
+	create.Prepare(privKey.PubKey(), 1)
+	swap(create)
+	c.SetChainID("other_chain")
+	if _, err := c.CreateAccount("someone"); err == nil {
+		t.Error("Expected client to refuse an action for another chain")
+	}
+}
@@ -0,0 +1,341 @@
+package client
+
+import (
+	"encoding/json"
+	"github.com/ipfs/go-ipfs/blocks"
+	core "github.com/ipfs/go-ipfs/core"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	tndr "github.com/tendermint/tendermint/types"
+	tmsp "github.com/tendermint/tmsp/types"
+	"github.com/zballs/comit/receipts"
+	"github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"gx/ipfs/QmcEcrBAMrwMyhSjXt4yfyPpzgSuV8HLHavnfmiKCSRqZU/go-cid"
+	"sync"
+	"time"
+)
+
+// NodeClient talks to a node directly through a Proxy. Forms
+// are stored in the client's own content store, so a node's
+// users see them only if the stores share content, e.g. IPFS.
+
+type ContentStore interface {
+	Put(data []byte) (*cid.Cid, error)
+	Get(contentID *cid.Cid) ([]byte, error)
+}
+
+type NodeClient struct {
+	proxy   *Proxy
+	privKey crypto.PrivKey
+	content ContentStore
+
+	mtx     sync.Mutex
+	chainID string
+
+	// Held from reading the sequence until CheckTx has
+	// counted the tx, so concurrent actions don't share one
+	broadcastMtx sync.Mutex
+}
+
+func NewNodeClient(proxy *Proxy, privKey crypto.PrivKey, content ContentStore) *NodeClient {
+	return &NodeClient{
+		proxy:   proxy,
+		privKey: privKey,
+		content: content,
+	}
+}
+
+func (c *NodeClient) query(query []byte) ([]byte, error) {
+	result, err := c.proxy.TMSPQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	err = ResultToError(result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Result.Data, nil
+}
+
+func (c *NodeClient) ChainID() (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.chainID != "" {
+		return c.chainID, nil
+	}
+	data, err := c.query(EmptyQuery(QueryChainID))
+	if err != nil {
+		return "", err
+	}
+	chainID, _, err := wire.GetByteSlice(data)
+	if err != nil {
+		return "", errors.Wrap(err, "decoding chain ID")
+	}
+	c.chainID = string(chainID)
+	return c.chainID, nil
+}
+
+// Pending sequence, counting txs that passed CheckTx;
+// zero for an address with no account yet
+func (c *NodeClient) sequence() (int, error) {
+	result, err := c.proxy.TMSPQuery(KeyQuery(c.privKey.PubKey().Address(), QuerySequence))
+	if err != nil {
+		return 0, err
+	}
+	if result.Result.Code == tmsp.CodeType_BaseUnknownAddress {
+		return 0, nil
+	}
+	err = ResultToError(result)
+	if err != nil {
+		return 0, err
+	}
+	var seq int
+	err = wire.ReadBinaryBytes(result.Result.Data, &seq)
+	return seq, err
+}
+
+// Signs the action with the next sequence and broadcasts it.
+// The same steps as the manager, with the client's key.
+
+func (c *NodeClient) broadcast(action Action) error {
+	c.broadcastMtx.Lock()
+	defer c.broadcastMtx.Unlock()
+	chainID, err := c.ChainID()
+	if err != nil {
+		return err
+	}
+	seq, err := c.sequence()
+	if err != nil {
+		return err
+	}
+	status, err := c.proxy.GetStatus()
+	if err != nil {
+		return err
+	}
+	action.Prepare(c.privKey.PubKey(), seq+1)
+	action.SetValidUntil(status.LatestBlockHeight + ValidBlocks)
+	action.Sign(c.privKey, chainID)
+	result, err := c.proxy.BroadcastTx("sync", action.Tx())
+	if err != nil {
+		return err
+	}
+	return ResultToError(result)
+}
+
+func (c *NodeClient) CreateAccount(username string) (*NewAccount, error) {
+	action := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte(username)))
+	err := c.broadcast(action)
+	if err != nil {
+		return nil, err
+	}
+	return &NewAccount{PubKeystr: PubKeytoHexstr(c.privKey.PubKey())}, nil
+}
+
+func (c *NodeClient) RemoveAccount() error {
+	return c.broadcast(NewAction(ActionRemoveAccount, nil))
+}
+
+func (c *NodeClient) SubmitForm(req *FormRequest) (*Idpair, error) {
+	submittedAt := time.Now()
+	form, err := newForm(req, c.privKey.PubKey(), submittedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading media")
+	}
+	contentID, err := c.content.Put(wire.BinaryBytes(form))
+	if err != nil {
+		return nil, errors.Wrap(err, "storing form")
+	}
+	data, err := json.Marshal(NewInfo(contentID, form, submittedAt))
+	if err != nil {
+		return nil, err
+	}
+	err = c.broadcast(NewAction(ActionSubmitForm, data))
+	if err != nil {
+		return nil, err
+	}
+	return NewIdpair(form, contentID), nil
+}
+
+func heightQuery(query []byte, height int) []byte {
+	if height > 0 {
+		return HeightQuery(height, query)
+	}
+	return query
+}
+
+func (c *NodeClient) FindForm(formID string, height int) (*Form, error) {
+	id := HexstrToBytes(formID)
+	if len(id) != FORM_ID_LENGTH {
+		return nil, errors.New("Invalid form ID")
+	}
+	data, err := c.query(heightQuery(KeyQuery(state.FormKey(id), QueryValue), height))
+	if err != nil {
+		return nil, err
+	}
+	return c.getForm(data)
+}
+
+func (c *NodeClient) getForm(contentIDJSON []byte) (*Form, error) {
+	contentID := &cid.Cid{}
+	err := contentID.UnmarshalJSON(contentIDJSON)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding content ID")
+	}
+	data, err := c.content.Get(contentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting form")
+	}
+	form := &Form{}
+	err = wire.ReadBinaryBytes(data, form)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding form")
+	}
+	return form, nil
+}
+
+func (c *NodeClient) FindAccount(pubKey crypto.PubKey, height int) (*Account, error) {
+	data, err := c.query(heightQuery(KeyQuery(state.AccountKey(pubKey.Address()), QueryValue), height))
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAccount(data)
+}
+
+func (c *NodeClient) SearchForms(search Search) ([]string, error) {
+	data, err := c.query(KeyQuery(wire.BinaryBytes(search), QuerySearch))
+	if err != nil {
+		return nil, err
+	}
+	formIDs := []string{}
+	if len(data) == 0 {
+		return formIDs, nil
+	}
+	var datas [][]byte
+	err = wire.ReadBinaryBytes(data, &datas)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range datas {
+		formIDs = append(formIDs, BytesToHexstr(data))
+	}
+	return formIDs, nil
+}
+
+// Subscribe reads new blocks from the node's websocket. A
+// client can have one feed at a time, since the proxy has
+// one websocket connection.
+
+func (c *NodeClient) Subscribe(issue string) (*Feed, error) {
+	err := c.proxy.StartWS()
+	if err != nil {
+		return nil, err
+	}
+	err = c.proxy.SubscribeNewBlock()
+	if err != nil {
+		c.proxy.StopWS()
+		return nil, err
+	}
+	updates := make(chan *Update)
+	done := make(chan struct{})
+	send := func(v interface{}, err error) bool {
+		update, _ := NewUpdate(v, err)
+		select {
+		case updates <- update:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	go func() {
+		defer close(updates)
+		var evDataBlock tndr.EventDataNewBlock
+		var pending []*Receipt
+		for {
+			evData, err := c.proxy.ReadResult("NewBlock", &evDataBlock)
+			if err != nil {
+				send(nil, errors.Wrap(err, "reading block"))
+				return
+			}
+			var block *tndr.Block
+			switch evData := evData.(type) {
+			case tndr.EventDataNewBlock:
+				block = evData.Block
+			case *tndr.EventDataNewBlock:
+				block = evData.Block
+			}
+			if block == nil {
+				continue
+			}
+			// Receipts go out once the header with the
+			// form's app hash has a commit, a block later
+			var waiting []*Receipt
+			for _, receipt := range pending {
+				if block.Height <= receipt.BlockHeight {
+					waiting = append(waiting, receipt)
+					continue
+				}
+				if !send(receipts.Build(c.proxy, HexstrToBytes(receipt.FormID), receipt.BlockHeight)) {
+					return
+				}
+			}
+			pending = waiting
+			for _, tx := range block.Txs {
+				var action Action
+				var info Info
+				if wire.ReadBinaryBytes(tx, &action) != nil || action.Type != ActionSubmitForm {
+					continue
+				}
+				if json.Unmarshal(action.Data, &info) != nil {
+					continue
+				}
+				if PubKeyHexstrEqual(info.Submitter, c.privKey.PubKey()) {
+					pending = append(pending, NewReceipt(block.Height+1, info.FormID))
+				}
+				if info.Issue != issue {
+					continue
+				}
+				contentID, _ := info.ContentID.MarshalJSON()
+				form, err := c.getForm(contentID)
+				if err != nil {
+					if !send(nil, errors.Wrapf(err, "Failed to get form %X", info.FormID)) {
+						return
+					}
+					continue
+				}
+				if !send(form, nil) {
+					return
+				}
+			}
+		}
+	}()
+	closeFeed := func() error {
+		close(done)
+		c.proxy.UnsubscribeNewBlock()
+		return c.proxy.StopWS()
+	}
+	return &Feed{Updates: updates, close: closeFeed}, nil
+}
+
+// Stores forms in an IPFS node
+type IPFSStore struct {
+	node *core.IpfsNode
+}
+
+func NewIPFSStore(node *core.IpfsNode) *IPFSStore {
+	return &IPFSStore{node}
+}
+
+func (s *IPFSStore) Put(data []byte) (*cid.Cid, error) {
+	return s.node.Blocks.AddBlock(blocks.NewBlock(data))
+}
+
+func (s *IPFSStore) Get(contentID *cid.Cid) ([]byte, error) {
+	b, err := s.node.Blocks.GetBlock(s.node.Context(), contentID)
+	if err != nil {
+		return nil, err
+	}
+	return b.RawData(), nil
+}
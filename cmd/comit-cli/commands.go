@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	core "github.com/ipfs/go-ipfs/core"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	"github.com/pkg/errors"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	"github.com/zballs/comit/client"
+	"github.com/zballs/comit/receipts"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+type options struct {
+	rpc      *string
+	keyFile  *string
+	ipfsRepo *string
+	timezone *string
+}
+
+func addOptions(fs *flag.FlagSet) *options {
+	return &options{
+		rpc:      fs.String("rpc", "tcp://0.0.0.0:46657", "Address of tendermint core rpc server"),
+		keyFile:  fs.String("key", "key.json", "Key file written by keygen"),
+		ipfsRepo: fs.String("ipfs", "~/.ipfs", "IPFS repo for form content"),
+		timezone: fs.String("timezone", "Local", "Municipal timezone, e.g. America/Chicago"),
+	}
+}
+
+func parse(fs *flag.FlagSet, args []string, opts *options) {
+	fs.Parse(args)
+	check(SetTimezone(*opts.timezone), "timezone")
+}
+
+func (opts *options) proxy() *Proxy {
+	return NewProxy(*opts.rpc, "/websocket")
+}
+
+// Key files. The recovery phrase is a second copy of
+// the key, so it is only stored with -save-mnemonic.
+
+type keyFile struct {
+	KeyType    string `json:"key_type"`
+	PubKeystr  string `json:"pub_key"`
+	PrivKeystr string `json:"priv_key"`
+	Mnemonic   string `json:"mnemonic,omitempty"`
+}
+
+func (opts *options) privKey() crypto.PrivKey {
+	data, err := ioutil.ReadFile(*opts.keyFile)
+	check(err, "reading key file")
+	var kf keyFile
+	check(json.Unmarshal(data, &kf), "decoding key file")
+	privKey, err := PrivKeyfromHexstr(kf.PrivKeystr)
+	check(err, *opts.keyFile)
+	return privKey
+}
+
+// Opens the IPFS node only for the commands that need form content
+func (opts *options) content() client.ContentStore {
+	r, err := fsrepo.Open(*opts.ipfsRepo)
+	check(err, "opening IPFS repo")
+	node, err := core.NewNode(context.Background(), &core.BuildCfg{
+		Repo:   r,
+		Online: true,
+	})
+	check(err, "starting IPFS node")
+	return client.NewIPFSStore(node)
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "\t")
+	check(err, "encoding output")
+	fmt.Println(string(data))
+}
+
+//------------------------------------------------//
+
+func keygen(args []string) {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyType := fs.String("type", KeyTypeEd25519, "Key type: ed25519 or secp256k1")
+	mnemonic := fs.String("mnemonic", "", "Recover the key from this phrase instead of a new one")
+	out := fs.String("out", "key.json", "File to write the key to")
+	saveMnemonic := fs.Bool("save-mnemonic", false, "Also store the recovery phrase in the key file")
+	fs.Parse(args)
+
+	phrase := *mnemonic
+	if phrase == "" {
+		var err error
+		phrase, err = NewMnemonic()
+		check(err, "generating phrase")
+	}
+	pubKey, privKey, err := KeypairFromMnemonic(*keyType, phrase)
+	check(err, "deriving key")
+
+	kf := &keyFile{
+		KeyType:    *keyType,
+		PubKeystr:  PubKeytoHexstr(pubKey),
+		PrivKeystr: PrivKeytoHexstr(privKey),
+	}
+	if *saveMnemonic {
+		kf.Mnemonic = phrase
+	}
+	data, err := json.MarshalIndent(kf, "", "\t")
+	check(err, "encoding key")
+
+	// Don't overwrite another key
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	check(err, "writing key")
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	}
+	check(err, "writing key")
+
+	fmt.Println("pub_key:", PubKeytoHexstr(pubKey))
+	if *mnemonic == "" {
+		fmt.Println(Fmt("Write down this recovery phrase; it recovers the key if %v is lost:", *out))
+		fmt.Println(phrase)
+	}
+}
+
+func createAccount(args []string) {
+	fs := flag.NewFlagSet("create-account", flag.ExitOnError)
+	opts := addOptions(fs)
+	username := fs.String("username", "", "Username for the account")
+	parse(fs, args, opts)
+
+	c := client.NewNodeClient(opts.proxy(), opts.privKey(), nil)
+	newAcc, err := c.CreateAccount(*username)
+	check(err, "creating account")
+	printJSON(newAcc)
+}
+
+func submit(args []string) {
+	fs := flag.NewFlagSet("submit", flag.ExitOnError)
+	opts := addOptions(fs)
+	issue := fs.String("issue", "", "Issue the form is about")
+	location := fs.String("location", "", "Location of the issue")
+	description := fs.String("description", "", "Description of the issue")
+	media := fs.String("media", "", "Media file, e.g. a photo")
+	contentType := fs.String("content-type", "", "Media content type; by default from the file extension")
+	parse(fs, args, opts)
+
+	if *media == "" {
+		Exit("submit: -media is required")
+	}
+	data, err := ioutil.ReadFile(*media)
+	check(err, "reading media")
+	if *contentType == "" {
+		*contentType = mime.TypeByExtension(filepath.Ext(*media))
+	}
+
+	c := client.NewNodeClient(opts.proxy(), opts.privKey(), opts.content())
+	idpair, err := c.SubmitForm(&client.FormRequest{
+		Issue:       *issue,
+		Location:    *location,
+		Description: *description,
+		ContentType: *contentType,
+		Media:       bytes.NewReader(data),
+	})
+	check(err, "submitting form")
+	printJSON(idpair)
+}
+
+func find(args []string) {
+	fs := flag.NewFlagSet("find", flag.ExitOnError)
+	opts := addOptions(fs)
+	formID := fs.String("form-id", "", "Form ID in hex")
+	height := fs.Int("height", 0, "Height to read the form at, or 0 for the latest")
+	out := fs.String("media-out", "", "Write the form's media to this file")
+	parse(fs, args, opts)
+
+	c := client.NewNodeClient(opts.proxy(), nil, opts.content())
+	form, err := c.FindForm(*formID, *height)
+	check(err, "finding form")
+	if *out != "" {
+		check(WriteFile(*out, form.Data, 0644), "writing media")
+		form.Data = nil
+	}
+	printJSON(form)
+}
+
+func search(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	opts := addOptions(fs)
+	issue := fs.String("issue", "", "Issue to search for")
+	after := fs.String("after", "", "Forms committed after this time or date")
+	before := fs.String("before", "", "Forms committed before this time or date")
+	parse(fs, args, opts)
+
+	s, err := NewSearch(*after, *before, *issue)
+	check(err, "search")
+	c := client.NewNodeClient(opts.proxy(), nil, nil)
+	formIDs, err := c.SearchForms(s)
+	check(err, "searching forms")
+	for _, formID := range formIDs {
+		fmt.Println(formID)
+	}
+}
+
+func watch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts := addOptions(fs)
+	issue := fs.String("issue", "", "Issue to print forms for")
+	receiptDir := fs.String("receipts", "", "Also save receipts in this directory, one file per form")
+	parse(fs, args, opts)
+
+	// Receipts are for forms submitted with the key
+	c := client.NewNodeClient(opts.proxy(), opts.privKey(), opts.content())
+	feed, err := c.Subscribe(*issue)
+	check(err, "subscribing")
+	defer feed.Close()
+	for update := range feed.Updates {
+		data, err := json.Marshal(update)
+		check(err, "encoding update")
+		fmt.Println(string(data))
+		if *receiptDir != "" && update.Receipt != nil && update.Error == "" {
+			path := filepath.Join(*receiptDir, update.Receipt.FormID+".json")
+			check(receipts.Save(path, update.Receipt), "saving receipt")
+		}
+	}
+}
+
+// Needs no network; the receipt carries its proof and commit,
+// and is checked against validators from a file the user trusts
+
+func verifyReceipt(args []string) {
+	fs := flag.NewFlagSet("verify-receipt", flag.ExitOnError)
+	receiptFile := fs.String("receipt", "", "Receipt file, as sent in an updates feed")
+	genesis := fs.String("genesis", "", "Trusted tendermint genesis file")
+	validators := fs.String("validators", "", "Trusted validators file, for a set that changed since genesis")
+	chainID := fs.String("chain-id", "", "Chain ID for the validators file")
+	fs.Parse(args)
+
+	trust, err := receipts.LoadTrust(*genesis, *validators, *chainID)
+	check(err, "reading trusted validators")
+	receipt, err := receipts.Load(*receiptFile)
+	check(err, "reading receipt")
+	rp, err := receipts.Verify(receipt, trust)
+	check(err, "receipt does not verify")
+	fmt.Println(Fmt("Verified form %v in app hash %X at height %d of chain %v, committed %v",
+		receipt.FormID, receipt.AppHash, receipt.BlockHeight, rp.Header.ChainID, rp.Header.Time))
+}
+
+func block(args []string) {
+	fs := flag.NewFlagSet("block", flag.ExitOnError)
+	opts := addOptions(fs)
+	height := fs.Int("height", 0, "Block height, or 0 for the latest")
+	parse(fs, args, opts)
+
+	proxy := opts.proxy()
+	if *height == 0 {
+		status, err := proxy.GetStatus()
+		check(err, "getting status")
+		*height = status.LatestBlockHeight
+	}
+	result, err := proxy.GetBlock(*height)
+	check(err, "getting block")
+	b := result.Block
+
+	fmt.Println(Fmt("Height:    %d", b.Height))
+	fmt.Println(Fmt("Time:      %v", b.Time))
+	fmt.Println(Fmt("Hash:      %X", b.Hash()))
+	fmt.Println(Fmt("App hash:  %X", b.AppHash))
+	fmt.Println(Fmt("Txs:       %d", len(b.Txs)))
+	for i, tx := range b.Txs {
+		var action Action
+		err := wire.ReadBinaryBytes(tx, &action)
+		if err != nil {
+			err = errors.Wrap(err, "decoding action")
+			fmt.Println(Fmt("[%d] %v", i, err))
+			continue
+		}
+		fmt.Println(Fmt("[%d] %v", i, action))
+	}
+}
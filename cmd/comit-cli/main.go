@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	. "github.com/tendermint/go-common"
+	"os"
+	"sort"
+)
+
+// comit-cli talks to a node through its RPC, with the same
+// action signing as the manager. Keys are kept in key files
+// written by keygen.
+
+type command struct {
+	usage string
+	run   func(args []string)
+}
+
+var commands = map[string]command{
+	"keygen":         {"Generate a key from a new recovery phrase, or recover one", keygen},
+	"create-account": {"Create an account for a key", createAccount},
+	"submit":         {"Submit a form with a media file", submit},
+	"find":           {"Find a form by ID", find},
+	"search":         {"Search forms by issue and time", search},
+	"watch":          {"Print forms for an issue and receipts as blocks are committed", watch},
+	"verify-receipt": {"Check a receipt file offline", verifyReceipt},
+	"block":          {"Print a block and its actions", block},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: comit-cli <command> [flags]\n\nCommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun comit-cli <command> -h for the command's flags.")
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	cmd.run(flag.Args()[1:])
+}
+
+func check(err error, context string) {
+	if err != nil {
+		Exit(context + ": " + err.Error())
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zballs/comit/receipts"
+	"os"
+)
+
+// comit-verify checks receipt files with no network access,
+// against a genesis or validators file the user trusts. It
+// exits non-zero if any receipt does not verify.
+
+func main() {
+	genesis := flag.String("genesis", "", "Trusted tendermint genesis file")
+	validators := flag.String("validators", "", "Trusted validators file, for a set that changed since genesis")
+	chainID := flag.String("chain-id", "", "Chain ID for the validators file")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: comit-verify -genesis <file> <receipt file>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	trust, err := receipts.LoadTrust(*genesis, *validators, *chainID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	failed := false
+	for _, path := range flag.Args() {
+		receipt, err := receipts.Load(path)
+		if err == nil {
+			_, err = receipts.Verify(receipt, trust)
+		}
+		if err != nil {
+			fmt.Printf("%v: FAILED: %v\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%v: OK form %v at height %d\n", path, receipt.FormID, receipt.BlockHeight)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -7,10 +7,11 @@ import (
 	. "github.com/tendermint/go-common"
 	"github.com/tendermint/tmsp/server"
 	"github.com/zballs/comit/app"
+	"github.com/zballs/comit/keystore"
 	"github.com/zballs/comit/manager"
+	"github.com/zballs/comit/types"
 	. "github.com/zballs/comit/util"
 	"net/http"
-	"reflect"
 )
 
 func main() {
@@ -20,8 +21,34 @@ func main() {
 	// User
 	rpcPtr := flag.String("rpc", "tcp://0.0.0.0:46657", "Address of tendermint core rpc server")
 	genFilePath := flag.String("genesis", "genesis.json", "Genesis file, if any")
+	tzPtr := flag.String("timezone", "Local", "Municipal timezone, e.g. America/Chicago")
+	exportPtr := flag.String("export-genesis", "", "Write the node's current state as genesis to this file and exit")
+	exportStatePtr := flag.String("export-state", "", "Write the node's committed state to this file (.json or binary) and exit")
+	importStatePtr := flag.String("import-state", "", "Start the chain from an exported state file instead of genesis")
+	importChainIDPtr := flag.String("import-chain-id", "", "Chain ID for the imported state; must differ from the exported chain's")
+	exportHeightPtr := flag.Int("export-height", 0, "Height of the state to export, or 0 for the latest")
+	keystorePtr := flag.String("keystore", "", "Directory for encrypted user keys; enables username/password login")
+	keepVersionsPtr := flag.Int("keep-versions", app.DefaultKeepVersions, "Number of past heights to keep state for, or 0 to keep all (memory grows without bound)")
 	flag.Parse()
 
+	// Export genesis from a running node
+	if *exportPtr != "" {
+		exportGenesis(*rpcPtr, *exportPtr)
+		return
+	}
+
+	// Export state from a running node
+	if *exportStatePtr != "" {
+		exportState(*rpcPtr, *exportStatePtr, *exportHeightPtr)
+		return
+	}
+
+	// Set municipal timezone
+	err := SetTimezone(*tzPtr)
+	if err != nil {
+		Exit("timezone: " + err.Error())
+	}
+
 	// Create app client
 	cli, err := app.NewClient(*cliPtr, "socket")
 	if err != nil {
@@ -31,15 +58,40 @@ func main() {
 	// Create comit app
 	comitApp := app.NewApp(cli)
 
-	// If genesis file was specified, set key-value options
-	if *genFilePath != "" {
-		kvz := loadGenesis(*genFilePath)
-		for _, kv := range kvz {
-			log := comitApp.SetOption(kv.Key, kv.Value)
-			fmt.Println(Fmt("Set: %v=%v. Log: %v", kv.Key, kv.Value, log))
+	// Set pruning of historical state
+	if *keepVersionsPtr != app.DefaultKeepVersions {
+		err = comitApp.SetKeepVersions(*keepVersionsPtr)
+		if err != nil {
+			Exit("keep-versions: " + err.Error())
 		}
 	}
 
+	// If state file was specified, check it and load it at InitChain;
+	// otherwise if genesis file was specified, validate and apply it
+	if *importStatePtr != "" {
+		snap, err := app.LoadSnapshot(*importStatePtr)
+		if err != nil {
+			Exit(err.Error())
+		}
+		err = comitApp.ImportState(snap, *importChainIDPtr)
+		if err != nil {
+			Exit(Fmt("%s: %v", *importStatePtr, err))
+		}
+		fmt.Println(Fmt("Importing state: chain_id=%v (from %v), height=%d, %d items, app_hash=%X",
+			*importChainIDPtr, snap.ChainID, snap.Height, len(snap.Items), snap.AppHash))
+	} else if *genFilePath != "" {
+		gen, err := app.LoadGenesis(*genFilePath)
+		if err != nil {
+			Exit(err.Error())
+		}
+		err = comitApp.ApplyGenesis(gen)
+		if err != nil {
+			Exit(Fmt("%s: %v", *genFilePath, err))
+		}
+		fmt.Println(Fmt("Applied genesis: chain_id=%v, %d issues, %d accounts",
+			gen.ChainID, len(gen.Issues), len(gen.Accounts)))
+	}
+
 	// Set state filters
 	// Just issues for now // TODO: add location
 	comitApp.SetFilters()
@@ -61,6 +113,15 @@ func main() {
 	// Create proxy manager
 	m := manager.CreateManager(*rpcPtr)
 
+	// Opt-in custody of user keys
+	if *keystorePtr != "" {
+		ks, err := keystore.NewKeystore(*keystorePtr)
+		if err != nil {
+			Exit("keystore: " + err.Error())
+		}
+		m.SetKeystore(ks)
+	}
+
 	// Add routes to multiplexer
 	m.AddRoutes(mux)
 
@@ -68,8 +129,16 @@ func main() {
 	js := JustFiles{http.Dir("static/")}
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(js)))
 
+	// Log requests, and recover from panics
+	// so one bad request can't stop the server
+	logger := types.NewLogger("http")
+	handler := manager.LogHandler(manager.RecoverHandler(mux, logger), logger)
+
 	// Start HTTP server with multiplexer
-	http.ListenAndServe(":8888", mux)
+	err = http.ListenAndServe(":8888", handler)
+	if err != nil {
+		Exit("http server: " + err.Error())
+	}
 
 	// Wait forever
 	TrapSignal(func() {
@@ -79,42 +148,32 @@ func main() {
 
 //------------------------------------------------//
 
-type KeyValue struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-}
-
-func loadGenesis(filePath string) (kvz []KeyValue) {
-	kvz_ := []interface{}{}
-	bytes, err := ReadFile(filePath)
+func exportGenesis(remote, filePath string) {
+	proxy := types.NewProxy(remote, "/websocket")
+	gen, err := app.ExportGenesis(proxy)
+	if err != nil {
+		Exit("exporting genesis: " + err.Error())
+	}
+	data, err := json.MarshalIndent(gen, "", "\t")
 	if err != nil {
-		Exit("loading genesis file: " + err.Error())
+		Exit("encoding genesis: " + err.Error())
 	}
-	err = json.Unmarshal(bytes, &kvz_)
+	err = WriteFile(filePath, data, 0644)
 	if err != nil {
-		Exit("parsing genesis file: " + err.Error())
+		Exit("writing genesis: " + err.Error())
 	}
-	if len(kvz_)%2 != 0 {
-		Exit("genesis cannot have an odd number of items.  Format = [key1, value1, key2, value2, ...]")
+	fmt.Println(Fmt("Exported genesis to %v", filePath))
+}
+
+func exportState(remote, filePath string, height int) {
+	proxy := types.NewProxy(remote, "/websocket")
+	snap, err := app.ExportState(proxy, height)
+	if err != nil {
+		Exit("exporting state: " + err.Error())
 	}
-	for i := 0; i < len(kvz_); i += 2 {
-		keyIfc := kvz_[i]
-		valueIfc := kvz_[i+1]
-		var key, value string
-		key, ok := keyIfc.(string)
-		if !ok {
-			Exit(Fmt("genesis had invalid key %v of type %v", keyIfc, reflect.TypeOf(keyIfc)))
-		}
-		if value_, ok := valueIfc.(string); ok {
-			value = value_
-		} else {
-			valueBytes, err := json.Marshal(valueIfc)
-			if err != nil {
-				Exit(Fmt("genesis had invalid value %v: %v", value_, err.Error()))
-			}
-			value = string(valueBytes)
-		}
-		kvz = append(kvz, KeyValue{key, value})
+	err = app.WriteSnapshot(filePath, snap)
+	if err != nil {
+		Exit("writing state: " + err.Error())
 	}
-	return kvz
+	fmt.Println(Fmt("Exported state at height %d to %v", snap.Height, filePath))
 }
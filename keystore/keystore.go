@@ -0,0 +1,241 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Keystore keeps private keys for users who log in with a
+// username and password instead of holding their own keys.
+// Each key is encrypted with AES-GCM under a key derived from
+// the password with scrypt and a random salt, and stored in
+// its own file. The server never writes a key in the clear.
+
+const (
+	Version = 1
+
+	MinPasswordLength = 8
+
+	// scrypt parameters for new keys
+	ScryptN = 1 << 15
+	ScryptR = 8
+	ScryptP = 1
+
+	keyLength  = 32
+	saltLength = 32
+)
+
+var (
+	ErrKeyExists       = errors.New("A key is already stored for this username")
+	ErrKeyNotFound     = errors.New("No key is stored for this username")
+	ErrInvalidPassword = errors.New("Invalid username or password")
+)
+
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.\-]{1,64}$`)
+
+func ValidateUsername(username string) error {
+	if !usernameRegexp.MatchString(username) {
+		return errors.New("Username must be 1-64 letters, digits, '_', '.' or '-'")
+	}
+	return nil
+}
+
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return errors.Errorf("Password must be at least %d characters", MinPasswordLength)
+	}
+	return nil
+}
+
+type EncryptedKey struct {
+	Version    int    `json:"version"`
+	Username   string `json:"username"`
+	PubKey     []byte `json:"pub_key"`
+	ScryptN    int    `json:"scrypt_n"`
+	ScryptR    int    `json:"scrypt_r"`
+	ScryptP    int    `json:"scrypt_p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func EncryptKey(username string, privKey crypto.PrivKey, password string) (*EncryptedKey, error) {
+	err := ValidateUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	err = ValidatePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	key := &EncryptedKey{
+		Version:  Version,
+		Username: username,
+		PubKey:   privKey.PubKey().Bytes(),
+		ScryptN:  ScryptN,
+		ScryptR:  ScryptR,
+		ScryptP:  ScryptP,
+		Salt:     make([]byte, saltLength),
+	}
+	_, err = rand.Read(key.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	aead, err := key.cipher(password)
+	if err != nil {
+		return nil, err
+	}
+	key.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(key.Nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+	key.Ciphertext = aead.Seal(nil, key.Nonce, privKey.Bytes(), key.additionalData())
+	return key, nil
+}
+
+func (key *EncryptedKey) Decrypt(password string) (crypto.PrivKey, error) {
+	if key.Version != Version {
+		return nil, errors.Errorf("Unsupported key version %v", key.Version)
+	}
+	aead, err := key.cipher(password)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, key.Nonce, key.Ciphertext, key.additionalData())
+	if err != nil {
+		return nil, ErrInvalidPassword
+	}
+	privKey, err := crypto.PrivKeyFromBytes(plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding private key")
+	}
+	return privKey, nil
+}
+
+func (key *EncryptedKey) cipher(password string) (cipher.AEAD, error) {
+	derived, err := scrypt.Key([]byte(password), key.Salt, key.ScryptN, key.ScryptR, key.ScryptP, keyLength)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key")
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// The ciphertext is bound to the username and public
+// key, so a key file cannot be passed off as another
+func (key *EncryptedKey) additionalData() []byte {
+	return append([]byte(key.Username), key.PubKey...)
+}
+
+//------------------------------------------------//
+
+type Keystore struct {
+	mtx sync.Mutex
+	dir string
+}
+
+func NewKeystore(dir string) (*Keystore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating keystore directory")
+	}
+	return &Keystore{dir: dir}, nil
+}
+
+func (ks *Keystore) path(username string) string {
+	return filepath.Join(ks.dir, username+".json")
+}
+
+func (ks *Keystore) Save(key *EncryptedKey) error {
+	err := ValidateUsername(key.Username)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return errors.Wrap(err, "encoding key")
+	}
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+	f, err := os.OpenFile(ks.path(key.Username), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return ErrKeyExists
+	} else if err != nil {
+		return errors.Wrap(err, "creating key file")
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(ks.path(key.Username))
+		return errors.Wrap(err, "writing key file")
+	}
+	return nil
+}
+
+func (ks *Keystore) Load(username string) (*EncryptedKey, error) {
+	err := ValidateUsername(username)
+	if err != nil {
+		return nil, ErrKeyNotFound
+	}
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+	data, err := ioutil.ReadFile(ks.path(username))
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading key file")
+	}
+	key := &EncryptedKey{}
+	err = json.Unmarshal(data, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding key file")
+	}
+	return key, nil
+}
+
+// Unlock loads and decrypts the key for a username.
+// A missing key and a wrong password give the same
+// error, so logins do not reveal which usernames exist.
+
+func (ks *Keystore) Unlock(username, password string) (crypto.PrivKey, error) {
+	key, err := ks.Load(username)
+	if err == ErrKeyNotFound {
+		return nil, ErrInvalidPassword
+	} else if err != nil {
+		return nil, err
+	}
+	return key.Decrypt(password)
+}
+
+func (ks *Keystore) Delete(username string) error {
+	err := ValidateUsername(username)
+	if err != nil {
+		return ErrKeyNotFound
+	}
+	ks.mtx.Lock()
+	defer ks.mtx.Unlock()
+	err = os.Remove(ks.path(username))
+	if os.IsNotExist(err) {
+		return ErrKeyNotFound
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package keystore
+
+import (
+	"github.com/tendermint/go-crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ks, err := NewKeystore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privKey := crypto.GenPrivKeyEd25519()
+	key, err := EncryptKey("someone", privKey, "canyouguess?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Save(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Save(key); err != ErrKeyExists {
+		t.Errorf("Expected ErrKeyExists, got %v", err)
+	}
+
+	unlocked, err := ks.Unlock("someone", "canyouguess?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !unlocked.PubKey().Equals(privKey.PubKey()) {
+		t.Error("Expected the stored key")
+	}
+	if _, err := ks.Unlock("someone", "wrong password"); err != ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+	if _, err := ks.Unlock("nobody", "canyouguess?"); err != ErrInvalidPassword {
+		t.Errorf("Expected ErrInvalidPassword for unknown user, got %v", err)
+	}
+	if _, err := ks.Load("../someone"); err != ErrKeyNotFound {
+		t.Errorf("Expected path in username to be rejected, got %v", err)
+	}
+
+	// Key file moved to another username
+	key.Username = "someone_else"
+	if err := ks.Save(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Unlock("someone_else", "canyouguess?"); err == nil {
+		t.Error("Expected key bound to another username to fail")
+	}
+
+	if _, err := EncryptKey("someone", privKey, "short"); err == nil {
+		t.Error("Expected short password to be rejected")
+	}
+}
@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/zballs/comit/keystore"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Handlers read requests and write responses through a protocol,
+// so the same handler serves the url-encoded routes and /api/v1.
+
+type protocol interface {
+	values(req *http.Request) (url.Values, error)
+
+	// Errors in the request, before anything is sent to the app
+	fail(w http.ResponseWriter, status int, err error)
+
+	respond(w http.ResponseWriter, msg *Message)
+}
+
+type handler func(w http.ResponseWriter, req *http.Request, p protocol)
+
+// Url-encoded bodies, plain text errors and Messages
+
+type legacyProtocol struct{}
+
+func (legacyProtocol) values(req *http.Request) (url.Values, error) {
+	return UrlValues(req)
+}
+
+func (legacyProtocol) fail(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
+
+func (legacyProtocol) respond(w http.ResponseWriter, msg *Message) {
+	ManagerRespond(w, msg)
+}
+
+func (m *Manager) legacy(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		h(w, req, legacyProtocol{})
+	}
+}
+
+// JSON bodies, or URL parameters for GET, and APIResponses
+
+type apiProtocol struct{}
+
+func (apiProtocol) values(req *http.Request) (url.Values, error) {
+	if req.Method == "GET" {
+		return req.URL.Query(), nil
+	}
+	return jsonValues(req.Body)
+}
+
+func (apiProtocol) fail(w http.ResponseWriter, status int, err error) {
+	code := StatusToErrCode(status)
+	if apiErr, ok := errors.Cause(err).(*APIError); ok {
+		code = apiErr.Code
+	}
+	APIRespond(w, status, &APIResponse{
+		Error: NewAPIError(code, err.Error()),
+	})
+}
+
+func (apiProtocol) respond(w http.ResponseWriter, msg *Message) {
+	if msg.Error != nil {
+		status, apiErr := ErrorToAPI(msg.Error)
+		APIRespond(w, status, &APIResponse{Error: apiErr})
+		return
+	}
+	res := &APIResponse{Data: msg.Data}
+	if strings.HasPrefix(msg.Action, "sign_") {
+		res.Action = msg.Action
+	}
+	APIRespond(w, http.StatusOK, res)
+}
+
+// Only one method is allowed on each /api/v1 route
+
+func (m *Manager) api(method string, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			apiProtocol{}.fail(w, http.StatusMethodNotAllowed,
+				errors.Errorf("Method %v not allowed; use %v", req.Method, method))
+			return
+		}
+		h(w, req, apiProtocol{})
+	}
+}
+
+func APIRespond(w http.ResponseWriter, status int, res *APIResponse) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data, _ = json.Marshal(&APIResponse{
+			Error: NewAPIError(ErrCodeInternal, "Failed to encode response"),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Errors from the app keep their result code. Other
+// errors the handlers respond with are mapped here.
+
+func ErrorToAPI(err error) (int, *APIError) {
+	switch cause := errors.Cause(err).(type) {
+	case *APIError:
+		return ErrCodeToStatus(cause.Code), cause
+	case *ResultError:
+		return cause.APIError()
+	}
+	switch errors.Cause(err) {
+	case keystore.ErrKeyExists:
+		return http.StatusConflict, NewAPIError(ErrCodeConflict, err.Error())
+	case keystore.ErrKeyNotFound:
+		return http.StatusNotFound, NewAPIError(ErrCodeNotFound, err.Error())
+	case keystore.ErrInvalidPassword:
+		return http.StatusUnauthorized, NewAPIError(ErrCodeUnauthorized, err.Error())
+	}
+	return http.StatusInternalServerError, NewAPIError(ErrCodeInternal, err.Error())
+}
+
+// Fields of a JSON request object as form values. Fields
+// are strings, numbers or booleans; null fields are left out.
+
+func jsonValues(body io.Reader) (url.Values, error) {
+	vals := make(url.Values)
+	var fields map[string]interface{}
+	err := json.NewDecoder(body).Decode(&fields)
+	if err == io.EOF {
+		// Empty body
+		return vals, nil
+	}
+	if err != nil {
+		return nil, NewAPIError(ErrCodeEncoding, "Request body must be a JSON object")
+	}
+	for key, field := range fields {
+		switch field := field.(type) {
+		case string:
+			vals.Set(key, field)
+		case float64:
+			vals.Set(key, strconv.FormatFloat(field, 'f', -1, 64))
+		case bool:
+			vals.Set(key, strconv.FormatBool(field))
+		case nil:
+		default:
+			return nil, NewAPIError(ErrCodeBadRequest,
+				"Field "+key+" must be a string, number or boolean")
+		}
+	}
+	return vals, nil
+}
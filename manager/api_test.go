@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"encoding/json"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONValues(t *testing.T) {
+	vals, err := jsonValues(strings.NewReader(`{"form_id": "AB", "height": 12, "remove": true, "none": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals.Get("form_id") != "AB" || vals.Get("height") != "12" || vals.Get("remove") != "true" {
+		t.Errorf("Unexpected values %v", vals)
+	}
+	if _, ok := vals["none"]; ok {
+		t.Error("Expected null field to be left out")
+	}
+	vals, err = jsonValues(strings.NewReader(""))
+	if err != nil || len(vals) != 0 {
+		t.Errorf("Expected no values for empty body, got %v, %v", vals, err)
+	}
+	if _, err = jsonValues(strings.NewReader(`{"tags": ["a"]}`)); err == nil {
+		t.Error("Expected array field to be rejected")
+	}
+	if _, err = jsonValues(strings.NewReader(`form_id=AB`)); err == nil {
+		t.Error("Expected url-encoded body to be rejected")
+	}
+}
+
+func TestErrorToAPI(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&ResultError{tmsp.CodeType_BaseInvalidSequence, "bad sequence"}, http.StatusConflict, ErrCodeInvalidSequence},
+		{&ResultError{tmsp.CodeType_Unauthorized, "not admin"}, http.StatusForbidden, ErrCodeForbidden},
+		{&ResultError{CodeValueNotFound, "no value"}, http.StatusNotFound, ErrCodeNotFound},
+		{&ResultError{tmsp.CodeType(20), "app error"}, http.StatusUnprocessableEntity, ErrCodeRejected},
+		{errInvalidSignature, http.StatusUnauthorized, ErrCodeInvalidSignature},
+	}
+	for _, c := range cases {
+		status, apiErr := ErrorToAPI(c.err)
+		if status != c.status || apiErr.Code != c.code {
+			t.Errorf("%v: expected %v %v, got %v %v", c.err, c.status, c.code, status, apiErr.Code)
+		}
+	}
+}
+
+func TestAPIResponses(t *testing.T) {
+	m := &Manager{
+		sessions:   NewSessionStore(time.Minute),
+		pending:    NewPendingStore(time.Minute, MaxPending),
+		challenges: newRateLimiter(ChallengeLimit, ChallengeWindow),
+	}
+	do := func(h http.HandlerFunc, method, body string) (int, *APIResponse) {
+		req := httptest.NewRequest(method, "/", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		res := &APIResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+			t.Fatalf("Response is not JSON: %q", rec.Body.String())
+		}
+		return rec.Code, res
+	}
+
+	status, res := do(m.api("POST", m.Logout), "GET", "")
+	if status != http.StatusMethodNotAllowed || res.Error == nil || res.Error.Code != ErrCodeMethodNotAllowed {
+		t.Errorf("Expected method not allowed, got %v %+v", status, res.Error)
+	}
+	status, res = do(m.api("POST", m.Logout), "POST", "")
+	if status != http.StatusOK || res.Error != nil {
+		t.Errorf("Expected logout to succeed, got %v %+v", status, res.Error)
+	}
+	status, res = do(m.api("POST", m.LoginChallenge), "POST", `{"pub_key": "XYZ"}`)
+	if status != http.StatusBadRequest || res.Error == nil || res.Error.Code != ErrCodeBadRequest {
+		t.Errorf("Expected bad request, got %v %+v", status, res.Error)
+	}
+	status, res = do(m.api("POST", m.Broadcast), "POST", `{"id": "unknown"`)
+	if status != http.StatusBadRequest || res.Error == nil || res.Error.Code != ErrCodeEncoding {
+		t.Errorf("Expected encoding error, got %v %+v", status, res.Error)
+	}
+}
@@ -0,0 +1,263 @@
+package manager
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	"github.com/zballs/comit/keystore"
+	"github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"net/http"
+)
+
+// Custody is opt-in. With a keystore, users can create accounts
+// and log in with a username and password; the manager keeps
+// their keys encrypted and signs for them. Users can export
+// their key to take custody, or import a key they hold.
+
+var errNoKeystore = errors.New("Keystore is not enabled")
+
+func (m *Manager) SetKeystore(ks *keystore.Keystore) {
+	m.keystore = ks
+}
+
+func (m *Manager) unlockKey(username, password string) (crypto.PrivKey, error) {
+	if m.keystore == nil {
+		return nil, errNoKeystore
+	}
+	return m.keystore.Unlock(username, password)
+}
+
+func (m *Manager) queryAccount(addr []byte) (*Account, error) {
+	query := KeyQuery(state.AccountKey(addr), QueryValue)
+	result, err := m.proxy.TMSPQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	err = ResultToError(result)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAccount(result.Result.Data)
+}
+
+func (m *Manager) createCustodialAccount(w http.ResponseWriter, p protocol, username, password, keyType string) {
+
+	if m.keystore == nil {
+		p.fail(w, http.StatusBadRequest, errNoKeystore)
+		return
+	}
+
+	// Generate new keypair from a phrase the user can
+	// write down, and store the key encrypted
+	mnemonic, err := NewMnemonic()
+
+	if err != nil {
+		p.respond(w, MessageCreateAccount(nil, err))
+		return
+	}
+
+	pubKey, privKey, err := KeypairFromMnemonic(keyType, mnemonic)
+
+	if err != nil {
+		p.respond(w, MessageCreateAccount(nil, err))
+		return
+	}
+
+	key, err := keystore.EncryptKey(username, privKey, password)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = m.keystore.Save(key)
+
+	if err != nil {
+		p.respond(w, MessageCreateAccount(nil, err))
+		return
+	}
+
+	// Create action
+	action := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte(username)))
+
+	// Prepare and sign action
+	action.Prepare(pubKey, 1) // pass sequence=1
+	action.SetValidUntil(m.ValidUntil())
+	sig := privKey.Sign(action.SignBytes(m.getChainID()))
+
+	err = m.broadcastAction(w, p, &preparedAction{
+		action: action,
+		pubKey: pubKey,
+		name:   "create_account",
+		data: &NewAccount{
+			PubKeystr: PubKeytoHexstr(pubKey),
+			Mnemonic:  mnemonic,
+			Note:      MnemonicMigrationNote,
+		},
+	}, sig, nil)
+
+	if err != nil {
+		// No account, so nothing to keep the key for
+		m.keystore.Delete(username)
+	}
+}
+
+// ExportKey returns the caller's private key. With remove=true
+// the key is also deleted from the keystore, and from then on
+// the user signs for themselves.
+
+func (m *Manager) ExportKey(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	// Get request data
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	sess, err := m.session(req)
+
+	if err != nil {
+		p.fail(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	sess.Lock()
+	defer sess.Unlock()
+
+	if sess.keyName == "" {
+		p.fail(w, http.StatusBadRequest, errors.New("Session does not use the keystore"))
+		return
+	}
+
+	// Password is checked again before the key leaves
+	privKey, err := m.unlockKey(sess.keyName, vals.Get("password"))
+
+	if err != nil {
+		p.fail(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	if vals.Get("remove") == "true" {
+		err = m.keystore.Delete(sess.keyName)
+		if err != nil {
+			p.respond(w, MessageExportKey(nil, err))
+			return
+		}
+		sess.privKey = nil
+		sess.keyName = ""
+	}
+
+	p.respond(w, MessageExportKey(NewKeypair(privKey.PubKey(), privKey), nil))
+}
+
+// ImportKey stores the key for an existing account,
+// so the user can log in with username and password
+
+func (m *Manager) ImportKey(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	// Get request data
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	if m.keystore == nil {
+		p.fail(w, http.StatusBadRequest, errNoKeystore)
+		return
+	}
+
+	privKey, err := PrivKeyfromHexstr(vals.Get("priv_key"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Only keys for accounts on the chain
+	_, err = m.queryAccount(privKey.PubKey().Address())
+
+	if err != nil {
+		p.respond(w, MessageImportKey(err))
+		return
+	}
+
+	key, err := keystore.EncryptKey(vals.Get("username"), privKey, vals.Get("password"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = m.keystore.Save(key)
+
+	p.respond(w, MessageImportKey(err))
+}
+
+// RecoverAccount stores the key derived from an account's recovery
+// phrase under a new password, replacing any key kept for that user
+
+func (m *Manager) RecoverAccount(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	// Get request data
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	if m.keystore == nil {
+		p.fail(w, http.StatusBadRequest, errNoKeystore)
+		return
+	}
+
+	// Phrases derive a key of the type the account was created with
+	pubKey, privKey, err := KeypairFromMnemonic(vals.Get("key_type"), vals.Get("mnemonic"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Only keys for accounts on the chain
+	_, err = m.queryAccount(pubKey.Address())
+
+	if err != nil {
+		p.respond(w, MessageRecoverAccount(err))
+		return
+	}
+
+	username := vals.Get("username")
+	key, err := keystore.EncryptKey(username, privKey, vals.Get("password"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Replace the key stored under this username
+	stored, err := m.keystore.Load(username)
+
+	if err == nil {
+		if !bytes.Equal(stored.PubKey, pubKey.Bytes()) {
+			p.fail(w, http.StatusUnauthorized, errors.New("Username belongs to another account"))
+			return
+		}
+		err = m.keystore.Delete(username)
+		if err != nil {
+			p.respond(w, MessageRecoverAccount(err))
+			return
+		}
+	}
+
+	err = m.keystore.Save(key)
+
+	p.respond(w, MessageRecoverAccount(err))
+}
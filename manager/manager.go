@@ -4,21 +4,25 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/ipfs/go-ipfs/blocks"
 	core "github.com/ipfs/go-ipfs/core"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 	"github.com/pkg/errors"
-	"github.com/tendermint/go-merkle"
+	"github.com/tendermint/go-crypto"
 	wire "github.com/tendermint/go-wire"
 	tndr "github.com/tendermint/tendermint/types"
+	"github.com/zballs/comit/keystore"
+	"github.com/zballs/comit/receipts"
 	"github.com/zballs/comit/state"
 	. "github.com/zballs/comit/types"
 	. "github.com/zballs/comit/util"
 	"gx/ipfs/QmcEcrBAMrwMyhSjXt4yfyPpzgSuV8HLHavnfmiKCSRqZU/go-cid"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -28,23 +32,38 @@ type Manager struct {
 
 	logger Logger
 
-	acc     *PrivAccount
+	sessions   *SessionStore
+	pending    *PendingStore
+	challenges *rateLimiter
+	keystore   *keystore.Keystore
+
+	// Set by the chain_id and issues handlers
+	infoMtx sync.Mutex
 	chainID string
 	issues  []string
 
+	// Blocks are streamed once and sent
+	// to every updates connection
+	heightMtx    sync.Mutex
 	latestHeight int
-	blocks       chan *tndr.Block
+	streamOnce   sync.Once
+	subsMtx      sync.Mutex
+	subs         map[chan *blockEvent]struct{}
 
 	node   *core.IpfsNode
 	cancel context.CancelFunc
+	start  sync.Once
 }
 
 func CreateManager(remote string) *Manager {
 	return &Manager{
-		proxy:   NewProxy(remote, "/websocket"),
-		logger:  NewLogger("action-manager"),
-		blocks:  make(chan *tndr.Block),
-		chainID: "comit",
+		proxy:      NewProxy(remote, "/websocket"),
+		logger:     NewLogger("action-manager"),
+		sessions:   NewSessionStore(DefaultSessionTTL),
+		pending:    NewPendingStore(PendingTTL, MaxPending),
+		challenges: newRateLimiter(ChallengeLimit, ChallengeWindow),
+		subs:       make(map[chan *blockEvent]struct{}),
+		chainID:    "comit",
 	}
 }
 
@@ -58,18 +77,50 @@ func ManagerRespond(w http.ResponseWriter, res interface{}) {
 	w.Write(data)
 }
 
+// Routes that change state, or return keys, are POST only
+// and checked for CSRF; see csrf
+
 func (m *Manager) AddRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/issues", m.Issues)
-	mux.HandleFunc("/login", m.Login)
-	mux.HandleFunc("/create_account", m.CreateAccount)
-	mux.HandleFunc("/remove_account", m.RemoveAccount)
-	mux.HandleFunc("/submit_form", m.SubmitForm)
-	mux.HandleFunc("/find_form", m.FindForm)
-	mux.HandleFunc("/search_forms", m.SearchForms)
+	mux.HandleFunc("/chain_id", m.legacy(m.GetChainID))
+	mux.HandleFunc("/issues", m.legacy(m.Issues))
+	mux.HandleFunc("/login_challenge", m.legacy(csrf(m.LoginChallenge)))
+	mux.HandleFunc("/login", m.legacy(csrf(m.Login)))
+	mux.HandleFunc("/logout", m.legacy(csrf(m.Logout)))
+	mux.HandleFunc("/create_account", m.legacy(csrf(m.CreateAccount)))
+	mux.HandleFunc("/remove_account", m.legacy(csrf(m.RemoveAccount)))
+	mux.HandleFunc("/submit_form", m.legacy(csrf(m.SubmitForm)))
+	mux.HandleFunc("/broadcast", m.legacy(csrf(m.Broadcast)))
+	mux.HandleFunc("/export_key", m.legacy(csrf(m.ExportKey)))
+	mux.HandleFunc("/import_key", m.legacy(csrf(m.ImportKey)))
+	mux.HandleFunc("/recover_account", m.legacy(csrf(m.RecoverAccount)))
+	mux.HandleFunc("/find_form", m.legacy(m.FindForm))
+	mux.HandleFunc("/find_account", m.legacy(m.FindAccount))
+	mux.HandleFunc("/search_forms", m.legacy(m.SearchForms))
 	mux.HandleFunc("/updates", m.Updates)
+
+	// Versioned JSON API
+	mux.HandleFunc(APIPrefix+"/chain_id", m.api("GET", m.GetChainID))
+	mux.HandleFunc(APIPrefix+"/issues", m.api("GET", m.Issues))
+	mux.HandleFunc(APIPrefix+"/login_challenge", m.api("POST", csrf(m.LoginChallenge)))
+	mux.HandleFunc(APIPrefix+"/login", m.api("POST", csrf(m.Login)))
+	mux.HandleFunc(APIPrefix+"/logout", m.api("POST", csrf(m.Logout)))
+	mux.HandleFunc(APIPrefix+"/create_account", m.api("POST", csrf(m.CreateAccount)))
+	mux.HandleFunc(APIPrefix+"/remove_account", m.api("POST", csrf(m.RemoveAccount)))
+	mux.HandleFunc(APIPrefix+"/submit_form", m.api("POST", csrf(m.SubmitForm)))
+	mux.HandleFunc(APIPrefix+"/broadcast", m.api("POST", csrf(m.Broadcast)))
+	mux.HandleFunc(APIPrefix+"/export_key", m.api("POST", csrf(m.ExportKey)))
+	mux.HandleFunc(APIPrefix+"/import_key", m.api("POST", csrf(m.ImportKey)))
+	mux.HandleFunc(APIPrefix+"/recover_account", m.api("POST", csrf(m.RecoverAccount)))
+	mux.HandleFunc(APIPrefix+"/find_form", m.api("GET", m.FindForm))
+	mux.HandleFunc(APIPrefix+"/find_account", m.api("GET", m.FindAccount))
+	mux.HandleFunc(APIPrefix+"/search_forms", m.api("GET", m.SearchForms))
+	mux.HandleFunc(APIPrefix+"/updates", m.Updates)
 }
 
 // IPFS Node
+
+var errNoNode = NewAPIError(ErrCodeUnavailable, "IPFS node is not running")
+
 func (m *Manager) InitNode() error {
 	if m.node != nil {
 		return errors.New("Node already exists")
@@ -97,14 +148,14 @@ func (m *Manager) InitNode() error {
 
 // ChainID
 
-func (m *Manager) GetChainID(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) GetChainID(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	query := EmptyQuery(QueryChainID)
 
 	result, err := m.proxy.TMSPQuery(query)
 
 	if err != nil {
-		ManagerRespond(w, MessageChainID(err))
+		p.respond(w, MessageChainID("", err))
 		return
 	}
 
@@ -113,190 +164,287 @@ func (m *Manager) GetChainID(w http.ResponseWriter, req *http.Request) {
 	if err == nil {
 		data, _, err := wire.GetByteSlice(result.Result.Data)
 		if err == nil {
-			m.chainID = string(data)
+			m.setChainID(string(data))
 		}
 	}
 
-	ManagerRespond(w, MessageChainID(err))
+	p.respond(w, MessageChainID(m.getChainID(), err))
 }
 
 // Issues
 
-func (m *Manager) GetIssues(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) GetIssues(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	query := EmptyQuery(QueryIssues)
 
 	result, err := m.proxy.TMSPQuery(query)
 
 	if err != nil {
-		ManagerRespond(w, MessageIssues(nil, err))
+		p.respond(w, MessageIssues(nil, err))
 		return
 	}
 
 	err = ResultToError(result)
 
 	if err != nil {
-		ManagerRespond(w, MessageIssues(nil, err))
+		p.respond(w, MessageIssues(nil, err))
 		return
 	}
 
 	var issues []string
 	wire.ReadBinaryBytes(result.Result.Data, &issues)
-	m.issues = issues
+	m.setIssues(issues)
 
-	ManagerRespond(w, MessageIssues(m.issues, nil))
+	p.respond(w, MessageIssues(issues, nil))
 }
 
-func (m *Manager) Issues(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) Issues(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	issues := m.getIssues()
 
-	if len(m.issues) == 0 {
-		m.GetIssues(w, req)
+	if len(issues) == 0 {
+		m.GetIssues(w, req, p)
 		return
 	}
 
-	ManagerRespond(w, MessageIssues(m.issues, nil))
+	p.respond(w, MessageIssues(issues, nil))
 }
 
-func (m *Manager) Login(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) Login(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	// Get request data
-	vals, err := UrlValues(req)
+	vals, err := p.values(req)
 
 	if err != nil {
-		http.Error(w, "Failed to read request data", http.StatusBadRequest)
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
 		return
 	}
 
-	pubKeystr := vals.Get("pub_key")
-	privKeystr := vals.Get("priv_key")
+	var pubKey crypto.PubKey
+	var privKey crypto.PrivKey
+
+	username := vals.Get("username")
+	password := vals.Get("password")
+
+	if password != "" {
 
-	// PubKey
-	pubKey, err := PubKeyfromHexstr(pubKeystr)
+		// Unlock key in keystore
+		privKey, err = m.unlockKey(username, password)
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+		if err != nil {
+			p.fail(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		pubKey = privKey.PubKey()
+
+	} else {
+
+		// PubKey
+		pubKey, err = PubKeyfromHexstr(vals.Get("pub_key"))
+
+		if err != nil {
+			p.fail(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		// Verify signature on challenge from /login_challenge
+		err = m.checkChallenge(pubKey, vals.Get("challenge"), vals.Get("signature"))
+
+		if err != nil {
+			p.fail(w, http.StatusUnauthorized, err)
+			return
+		}
 	}
 
-	// PrivKey
-	privKey, err := PrivKeyfromHexstr(privKeystr)
+	// Query account
+	acc, err := m.queryAccount(pubKey.Address())
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		p.respond(w, MessageLogin(err))
 		return
 	}
 
-	// Verify keypair
-	sig := privKey.Sign([]byte(m.chainID))
-	verified := pubKey.VerifyBytes([]byte(m.chainID), sig)
+	m.SyncSequence(acc)
+
+	sess := NewSession(acc)
 
-	if !verified {
-		http.Error(w, "Invalid keypair", http.StatusUnauthorized)
+	if privKey != nil {
+		sess.privKey = privKey
+		sess.keyName = username
+	}
+
+	// Replace any session this browser already has
+	m.sessions.Delete(sessionToken(req))
+	token, err := m.sessions.Create(sess)
+
+	if err != nil {
+		p.respond(w, MessageLogin(err))
 		return
 	}
 
-	// Query account
-	acckey := state.AccountKey(pubKey.Address())
-	query := KeyQuery(acckey, QueryValue)
+	setSessionCookie(w, token)
+	p.respond(w, MessageLogin(nil))
+
+	// IPFS node and proxy ws are shared by all sessions
+	m.start.Do(func() {
+		err := m.InitNode()
+		if err != nil {
+			m.logger.Error("Failed to start IPFS node", "error", err)
+		}
+		err = m.proxy.StartWS()
+		if err != nil {
+			m.logger.Error("Failed to start websocket client", "error", err)
+		}
+	})
+}
+
+func (m *Manager) Logout(w http.ResponseWriter, req *http.Request, p protocol) {
+	m.sessions.Delete(sessionToken(req))
+	clearSessionCookie(w)
+	p.respond(w, MessageLogout(nil))
+}
+
+// Sequence
+
+// Pending sequence for an address, counting txs
+// that passed CheckTx but are not yet committed
+func (m *Manager) QuerySequence(addr []byte) (int, error) {
+
+	query := KeyQuery(addr, QuerySequence)
 
 	result, err := m.proxy.TMSPQuery(query)
 
-	if err == nil {
-		err = ResultToError(result)
-		if err == nil {
-			var acc *Account
-			wire.ReadBinaryBytes(result.Result.Data, &acc)
-			m.acc = NewPrivAccount(acc, privKey)
-		}
+	if err != nil {
+		return 0, err
 	}
 
-	ManagerRespond(w, MessageLogin(err))
+	err = ResultToError(result)
 
-	// Init IPFS node // check for err
-	m.InitNode()
+	if err != nil {
+		return 0, err
+	}
 
-	// Start proxy ws
-	m.proxy.StartWS()
+	var seq int
+	err = wire.ReadBinaryBytes(result.Result.Data, &seq)
+	return seq, err
 }
 
-// Create Account
-func (m *Manager) CreateAccount(w http.ResponseWriter, req *http.Request) {
-
-	// Get request data
-	vals, err := UrlValues(req)
-
+func (m *Manager) SyncSequence(acc *Account) {
+	seq, err := m.QuerySequence(acc.PubKey.Address())
 	if err != nil {
-		http.Error(w, "Failed to read request data", http.StatusBadRequest)
+		m.logger.Warn("Failed to sync sequence", "error", err)
 		return
 	}
+	acc.Sequence = seq
+}
 
-	username := vals.Get("username")
-	password := vals.Get("password")
-
-	// Create action
-	action := NewAction(ActionCreateAccount, []byte(username))
+// Expiry
 
-	// Generate new keypair
-	pubKey, privKey, err := GenerateKeypair(password)
+// Number of blocks an action signed by
+// the manager stays valid for
+const DefaultValidBlocks = 100
 
+func (m *Manager) ValidUntil() int {
+	status, err := m.proxy.GetStatus()
 	if err != nil {
-		panic(err)
+		m.logger.Warn("Failed to get status", "error", err)
+		latestHeight := m.getLatestHeight()
+		if latestHeight == 0 {
+			// No expiry rather than a wrong one
+			return 0
+		}
+		return latestHeight + DefaultValidBlocks
 	}
+	return status.LatestBlockHeight + DefaultValidBlocks
+}
 
-	// Prepare and sign action
-	action.Prepare(pubKey, 1) // pass sequence=1
-	action.Sign(privKey, m.chainID)
+// Create Account
+func (m *Manager) CreateAccount(w http.ResponseWriter, req *http.Request, p protocol) {
 
-	// Broadcast tx
-	result, err := m.proxy.BroadcastTx("sync", action.Tx())
+	// Get request data
+	vals, err := p.values(req)
 
 	if err != nil {
-		ManagerRespond(w, MessageCreateAccount(nil, err))
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
 		return
 	}
 
-	err = ResultToError(result)
+	username := vals.Get("username")
+
+	// Manager generates and stores the keypair
+	if vals.Get("password") != "" {
+		m.createCustodialAccount(w, p, username, vals.Get("password"), vals.Get("key_type"))
+		return
+	}
+
+	// Client generates the keypair
+	pubKey, err := PubKeyfromHexstr(vals.Get("pub_key"))
 
 	if err != nil {
-		ManagerRespond(w, MessageCreateAccount(nil, err))
+		p.fail(w, http.StatusBadRequest, err)
 		return
 	}
 
-	keypair := NewKeypair(pubKey, privKey)
+	// Create action
+	action := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte(username)))
 
-	ManagerRespond(w, MessageCreateAccount(keypair, nil))
+	// Prepare action for the client to sign
+	action.Prepare(pubKey, 1) // pass sequence=1
+	action.SetValidUntil(m.ValidUntil())
+
+	m.prepareAction(w, p, &preparedAction{
+		action: action,
+		pubKey: pubKey,
+		name:   "create_account",
+		data: &NewAccount{
+			PubKeystr: PubKeytoHexstr(pubKey),
+			Note:      MnemonicMigrationNote,
+		},
+	}, nil)
 }
 
-func (m *Manager) RemoveAccount(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) RemoveAccount(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	// Make sure we're logged in
-	if m.acc == nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	sess, err := m.session(req)
+
+	if err != nil {
+		p.fail(w, http.StatusUnauthorized, err)
 		return
 	}
 
+	sess.Lock()
+	defer sess.Unlock()
+	acc := sess.Account()
+
 	// Create action
 	action := NewAction(ActionRemoveAccount, nil)
 
-	// Prepare and sign action
-	action.Prepare(m.acc.PubKey, m.acc.Sequence)
-	action.Sign(m.acc.PrivKey, m.chainID)
-
-	// Broadcast tx
-	result, err := m.proxy.BroadcastTx("sync", action.Tx())
+	// Prepare action for the client to sign
+	action.Prepare(acc.PubKey, acc.Sequence+1)
+	action.SetValidUntil(m.ValidUntil())
 
-	if err == nil {
-		err = ResultToError(result)
-	}
-
-	ManagerRespond(w, MessageRemoveAccount(err))
+	m.prepareAction(w, p, &preparedAction{
+		action: action,
+		pubKey: acc.PubKey,
+		name:   "remove_account",
+		token:  sessionToken(req),
+	}, sess)
 }
 
-func (m *Manager) SubmitForm(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) SubmitForm(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	// Make sure we're logged in and node is running
-	if m.acc == nil || m.node == nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	sess, err := m.session(req)
+
+	if err != nil {
+		p.fail(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	if m.node == nil {
+		p.respond(w, MessageSubmitForm(nil, errNoNode))
 		return
 	}
 
@@ -304,303 +452,495 @@ func (m *Manager) SubmitForm(w http.ResponseWriter, req *http.Request) {
 	f, err := MultipartForm(req)
 
 	if err != nil {
-		http.Error(w, "Failed to read request data", http.StatusBadRequest)
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
 		return
 	}
 
-	// Form // TODO: field validation
 	var form Form
 
-	// Text // check lengths of slices?
-	form.Issue = f.Value["issue"][0]
-	form.Location = f.Value["location"][0]
-	form.Description = f.Value["description"][0]
-	form.SubmittedAt = time.Now().Local().String()
-	form.Submitter = PubKeytoHexstr(m.acc.PubKey)
+	// Text
+	form.Issue, err = formValue(f, "issue")
+
+	if err == nil {
+		form.Location, err = formValue(f, "location")
+	}
+
+	if err == nil {
+		form.Description, err = formValue(f, "description")
+	}
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	submittedAt := time.Now()
+	form.SubmittedAt = FormatTime(submittedAt)
+	form.Submitter = PubKeytoHexstr(sess.Account().PubKey)
 
 	// Media
+	if len(f.File["media"]) == 0 {
+		p.fail(w, http.StatusBadRequest, errors.New("Missing field media"))
+		return
+	}
+
 	media := f.File["media"][0]
 	form.ContentType = media.Header.Get("Content-Type")
 
 	file, err := media.Open()
 
-	if err != nil {
-		panic(err)
+	if err == nil {
+		form.Data, err = ioutil.ReadAll(file)
+		file.Close()
 	}
 
-	form.Data, err = ioutil.ReadAll(file)
-
 	if err != nil {
-		panic(err)
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read media"))
+		return
 	}
 
 	// Add IPFS block with form data
 	b := blocks.NewBlock(wire.BinaryBytes(form))
 	cid, err := m.node.Blocks.AddBlock(b)
+
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageSubmitForm(nil, errors.Wrap(err, "Failed to add form to IPFS")))
+		return
 	}
 
 	// Encode form info
-	data, err := json.Marshal(NewInfo(cid, form))
+	data, err := json.Marshal(NewInfo(cid, form, submittedAt))
+
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageSubmitForm(nil, errors.Wrap(err, "Failed to encode form info")))
+		return
 	}
 
 	// Create action
 	action := NewAction(ActionSubmitForm, data)
 
-	// Prepare and sign action
-	action.Prepare(m.acc.PubKey, m.acc.Sequence+1)
-	action.Sign(m.acc.PrivKey, m.chainID)
+	sess.Lock()
+	defer sess.Unlock()
+	acc := sess.Account()
+
+	// Prepare action for the client to sign
+	action.Prepare(acc.PubKey, acc.Sequence+1)
+	action.SetValidUntil(m.ValidUntil())
+
+	m.prepareAction(w, p, &preparedAction{
+		action: action,
+		pubKey: acc.PubKey,
+		name:   "submit_form",
+		token:  sessionToken(req),
+		data:   NewIdpair(form, cid),
+	}, sess)
+}
+
+func (m *Manager) FindForm(w http.ResponseWriter, req *http.Request, p protocol) {
 
-	// Broadcast tx
-	result, err := m.proxy.BroadcastTx("sync", action.Tx())
+	// Get values from request body
+	vals, err := p.values(req)
 
 	if err != nil {
-		ManagerRespond(w, MessageSubmitForm(nil, err))
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
 		return
 	}
 
-	err = ResultToError(result)
+	formID, err := hex.DecodeString(vals.Get("form_id"))
 
-	if err != nil {
-		ManagerRespond(w, MessageSubmitForm(nil, err))
+	if err != nil || len(formID) != FORM_ID_LENGTH {
+		p.fail(w, http.StatusBadRequest, errors.New("Invalid form ID"))
 		return
 	}
 
-	// CheckTx is ok so we can increment sequence
-	m.acc.Sequence++
-
-	idpair := NewIdpair(form, cid)
-	ManagerRespond(w, MessageSubmitForm(idpair, nil))
-}
-
-func (m *Manager) FindForm(w http.ResponseWriter, req *http.Request) {
-
-	// Get values from request body
-	vals, err := UrlValues(req)
+	height, err := heightParam(vals.Get("height"))
 
 	if err != nil {
-		http.Error(w, "Failed to read request data", http.StatusBadRequest)
+		p.fail(w, http.StatusBadRequest, err)
 		return
 	}
 
-	formID, err := hex.DecodeString(vals.Get("form_id"))
+	query := KeyQuery(state.FormKey(formID), QueryValue)
 
-	if err != nil || len(formID) != FORM_ID_LENGTH {
-		http.Error(w, "Invalid form ID", http.StatusBadRequest)
-		return
+	if height > 0 {
+		query = HeightQuery(height, query)
 	}
 
-	query := KeyQuery(formID, QueryValue)
-
 	result, err := m.proxy.TMSPQuery(query)
 
 	if err != nil {
-		ManagerRespond(w, MessageFindForm(nil, err))
+		p.respond(w, MessageFindForm(nil, err))
 		return
 	}
 
 	err = ResultToError(result)
 
 	if err != nil {
-		ManagerRespond(w, MessageFindForm(nil, err))
+		p.respond(w, MessageFindForm(nil, err))
+		return
+	}
+
+	if m.node == nil {
+		p.respond(w, MessageFindForm(nil, errNoNode))
 		return
 	}
 
 	// Decode content ID
 	contentID := &cid.Cid{}
 	err = contentID.UnmarshalJSON(result.Result.Data)
+
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageFindForm(nil, errors.Wrap(err, "Failed to decode content ID")))
+		return
 	}
 
 	// Get IPFS block with form data
 	b, err := m.node.Blocks.GetBlock(m.node.Context(), contentID)
+
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageFindForm(nil, errors.Wrap(err, "Failed to get form from IPFS")))
+		return
 	}
 
 	// Form
 	form := &Form{}
 	err = wire.ReadBinaryBytes(b.RawData(), form)
+
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageFindForm(nil, errors.Wrap(err, "Failed to decode form")))
+		return
 	}
 
-	ManagerRespond(w, MessageFindForm(form, nil))
+	p.respond(w, MessageFindForm(form, nil))
 }
 
-func (m *Manager) BlockStream(done <-chan struct{}) {
+func formValue(f *multipart.Form, key string) (string, error) {
+	if len(f.Value[key]) == 0 {
+		return "", errors.New("Missing field " + key)
+	}
+	return f.Value[key][0], nil
+}
+
+// Historical queries
+
+// An empty height reads the latest state
+func heightParam(heightstr string) (int, error) {
+	if heightstr == "" {
+		return 0, nil
+	}
+	height, err := strconv.Atoi(heightstr)
+	if err != nil || height <= 0 {
+		return 0, errors.New("Invalid height")
+	}
+	return height, nil
+}
+
+func (m *Manager) FindAccount(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	// Get values from request body
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	pubKey, err := PubKeyfromHexstr(vals.Get("pub_key"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.New("Invalid public key"))
+		return
+	}
+
+	height, err := heightParam(vals.Get("height"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	query := KeyQuery(state.AccountKey(pubKey.Address()), QueryValue)
+
+	if height > 0 {
+		query = HeightQuery(height, query)
+	}
+
+	result, err := m.proxy.TMSPQuery(query)
+
+	if err != nil {
+		p.respond(w, MessageFindAccount(nil, err))
+		return
+	}
+
+	err = ResultToError(result)
+
+	if err != nil {
+		p.respond(w, MessageFindAccount(nil, err))
+		return
+	}
+
+	acc, err := DecodeAccount(result.Result.Data)
+
+	p.respond(w, MessageFindAccount(acc, err))
+}
+
+// Block subscribers
+
+// Blocks buffered per subscriber before it misses blocks
+const SubscriberBuffer = MaxBlocks
+
+// Wait before restarting a failed block stream
+const StreamRetry = 5 * time.Second
+
+// A block, or the error the block stream failed with
+type blockEvent struct {
+	block *tndr.Block
+	err   error
+}
+
+func (m *Manager) SubscribeBlocks() chan *blockEvent {
+	m.streamOnce.Do(func() {
+		go m.runBlockStream()
+	})
+	ch := make(chan *blockEvent, SubscriberBuffer)
+	m.subsMtx.Lock()
+	m.subs[ch] = struct{}{}
+	m.subsMtx.Unlock()
+	return ch
+}
+
+func (m *Manager) UnsubscribeBlocks(ch chan *blockEvent) {
+	m.subsMtx.Lock()
+	delete(m.subs, ch)
+	m.subsMtx.Unlock()
+}
+
+// A slow subscriber misses blocks rather than holding up the others
+func (m *Manager) publish(event *blockEvent) {
+	m.subsMtx.Lock()
+	defer m.subsMtx.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- event:
+		default:
+			if event.block != nil {
+				m.logger.Warn("Subscriber missed block", "height", event.block.Height)
+			}
+		}
+	}
+}
+
+func (m *Manager) publishBlock(block *tndr.Block) {
+	m.publish(&blockEvent{block: block})
+}
+
+// Restarts the block stream when it fails, and tells
+// subscribers so they know they may have missed blocks
+func (m *Manager) runBlockStream() {
+	for {
+		err := m.BlockStream()
+		m.logger.Error("Block stream failed", "error", err)
+		m.publish(&blockEvent{err: errors.Wrap(err, "Block stream failed; restarting")})
+		time.Sleep(StreamRetry)
+	}
+}
+
+func (m *Manager) getChainID() string {
+	m.infoMtx.Lock()
+	defer m.infoMtx.Unlock()
+	return m.chainID
+}
+
+func (m *Manager) setChainID(chainID string) {
+	m.infoMtx.Lock()
+	m.chainID = chainID
+	m.infoMtx.Unlock()
+}
+
+func (m *Manager) getIssues() []string {
+	m.infoMtx.Lock()
+	defer m.infoMtx.Unlock()
+	return m.issues
+}
+
+func (m *Manager) setIssues(issues []string) {
+	m.infoMtx.Lock()
+	m.issues = issues
+	m.infoMtx.Unlock()
+}
+
+// Latest streamed height; handlers read it
+// while the stream goroutine sets it
+
+func (m *Manager) getLatestHeight() int {
+	m.heightMtx.Lock()
+	defer m.heightMtx.Unlock()
+	return m.latestHeight
+}
+
+func (m *Manager) setLatestHeight(height int) {
+	m.heightMtx.Lock()
+	m.latestHeight = height
+	m.heightMtx.Unlock()
+}
+
+func (m *Manager) BlockStream() error {
 
 	// Subscribe to new block event
 	err := m.proxy.SubscribeNewBlock()
 	if err != nil {
-		panic("Failed to subscribe to new block event")
+		return errors.Wrap(err, "subscribing to new block event")
 	}
 
 	// Get latest block height
-	status, err := m.proxy.GetStatus()
-	if err == nil {
-		m.latestHeight = status.LatestBlockHeight
+	if m.getLatestHeight() == 0 {
+		status, err := m.proxy.GetStatus()
+		if err == nil {
+			m.setLatestHeight(status.LatestBlockHeight)
+		}
 	}
 
 	var block *tndr.Block
 	var evDataBlock tndr.EventDataNewBlock
 	var evData tndr.TMEventData
 
-	m.logger.Info("Streaming blocks...", "start_height", m.latestHeight)
+	m.logger.Info("Streaming blocks...", "start_height", m.getLatestHeight())
 
 	for {
 
-		select {
+		evData, err = m.proxy.ReadResult("NewBlock", &evDataBlock)
+		if err != nil {
+			return errors.Wrap(err, "reading new block event")
+		}
 
-		case <-done:
-			err := m.proxy.UnsubscribeNewBlock()
-			if err != nil {
-				panic("Failed to unsubscribe from new block event")
-			}
-			m.logger.Info("Done streaming blocks")
-			return
+		switch evData.(type) {
 
-		default:
-			evData, err = m.proxy.ReadResult("NewBlock", &evDataBlock)
-			if err != nil {
-				panic(err)
-			}
+		case tndr.EventDataNewBlock:
+			block = evData.(tndr.EventDataNewBlock).Block
+		case *tndr.EventDataNewBlock:
+			// nil block
+			block = evData.(*tndr.EventDataNewBlock).Block
+		}
 
-			switch evData.(type) {
+		if block == nil {
+			continue
+		}
 
-			case tndr.EventDataNewBlock:
-				block = evData.(tndr.EventDataNewBlock).Block
-			case *tndr.EventDataNewBlock:
-				// nil block
-				block = evData.(*tndr.EventDataNewBlock).Block
-			}
+		latestHeight := m.getLatestHeight()
 
-			if block == nil {
-				continue
-			}
-
-			if m.latestHeight == block.Height {
-				time.Sleep(time.Second * 5)
-				continue
-			} else if m.latestHeight > block.Height {
-				//shouldn't happen
-			} else if m.latestHeight+1 < block.Height {
-				// missed block(s)
-				m.logger.Warn("Missed block(s)", "missed", block.Height-m.latestHeight)
-				// query missed blocks
-				// should this run in goroutine and
-				// should next read wait on its completion??
-				for h := m.latestHeight + 1; h <= block.Height; h++ {
-					result, err := m.proxy.GetBlock(h)
-					if err != nil {
-						panic(err)
-					}
-					m.blocks <- result.Block
+		if latestHeight == block.Height {
+			time.Sleep(time.Second * 5)
+			continue
+		} else if latestHeight > block.Height {
+			//shouldn't happen
+		} else if latestHeight+1 < block.Height {
+			// missed block(s)
+			m.logger.Warn("Missed block(s)", "missed", block.Height-latestHeight)
+			// query missed blocks
+			// should this run in goroutine and
+			// should next read wait on its completion??
+			for h := latestHeight + 1; h < block.Height; h++ {
+				result, err := m.proxy.GetBlock(h)
+				if err != nil {
+					return errors.Wrapf(err, "getting missed block %d", h)
 				}
+				m.publishBlock(result.Block)
+				m.setLatestHeight(h)
 			}
+		}
 
-			m.blocks <- block
+		m.publishBlock(block)
 
-			m.latestHeight = block.Height
-		}
+		m.setLatestHeight(block.Height)
 	}
 }
 
+// Writes an update; false if the connection is closed
+func writeUpdate(ws *websocket.Conn, v interface{}, err error) bool {
+	update, _ := NewUpdate(v, err)
+	return ws.WriteJSON(update) == nil
+}
+
 func (m *Manager) Updates(w http.ResponseWriter, req *http.Request) {
 
 	// This routine writes feed updates and blockchain receipts to ws
-	// What information should receipts include? Root hash, form ID, anything else?
+	// Receipts carry their proofs, so they can be checked offline
 
 	// Websocket connection
 	ws, err := Upgrader().Upgrade(w, req, nil)
 	if err != nil {
-		panic(err)
+		// Upgrade responded with an HTTP error
+		m.logger.Warn("Failed to upgrade connection", "error", err)
+		return
 	}
+	defer ws.Close()
 
-	// Make sure we've logged in
-	if m.acc == nil {
-		ws.WriteMessage(websocket.TextMessage, []byte("Not logged in"))
+	// Make sure we've logged in and node is running
+	sess, err := m.session(req)
+	if err != nil {
+		writeUpdate(ws, nil, err)
+		return
+	}
+	if m.node == nil {
+		writeUpdate(ws, nil, errNoNode)
 		return
 	}
 
 	// Get values from request body
 	_, data, err := ws.ReadMessage()
 	if err != nil {
-		panic(err)
+		// Connection closed
+		return
 	}
 
 	issue := string(data)
-	pubKeystr := PubKeytoHexstr(m.acc.PubKey)
+	pubKey := sess.Account().PubKey
 
 	var action Action
 	var form Form
 	var info Info
-	var proof merkle.IAVLProof
-	var receipt *Receipt
+	var pending []*Receipt
 
 	// Start block stream
-	done := make(chan struct{})
-	go m.BlockStream(done)
+	blocks := m.SubscribeBlocks()
+	defer m.UnsubscribeBlocks(blocks)
 
 	for {
 
 		m.logger.Info("Waiting for block...")
 
-		block, ok := <-m.blocks
+		event, ok := <-blocks
 
 		if !ok {
 			// Block channel closed
-			// shouldn't happen
-			panic("Block channel closed")
+			return
 		}
 
-		if receipt != nil {
-			// We have a receipt to send
-			if receipt.BlockHeight >= block.Height {
-				//shouldn't happen
-			} else if receipt.BlockHeight+1 == block.Height {
-				// Good, increment height
-				receipt.BlockHeight++
-			} else {
-				// We missed blocks
-				// Ok, set height anyway
-				receipt.BlockHeight = block.Height
-			}
-			// Query merkle proof for committed form and verify receipt
-			key := HexstrToBytes(receipt.FormID)
-			query := KeyQuery(key, QueryProof)
-			result, err := m.proxy.TMSPQuery(query)
-			if err == nil {
-				err = ResultToError(result)
-				if err == nil {
-					err = wire.ReadBinaryBytes(result.Result.Data, &proof)
-					if err == nil {
-						value, err := info.ContentID.MarshalJSON()
-						if err == nil {
-							verified := proof.Verify(key, value, block.AppHash)
-							if verified {
-								// Set app hash and write to ws
-								receipt.AppHash = block.AppHash
-								update, _ := NewUpdate(receipt, nil)
-								ws.WriteJSON(update)
-							} else {
-								err = errors.New("Failed to verify receipt")
-							}
-						}
-					}
-				}
+		if event.err != nil {
+			// Stream restarts; keep the connection
+			if !writeUpdate(ws, nil, event.err) {
+				return
 			}
+			continue
+		}
 
-			if err != nil {
-				update, _ := NewUpdate(receipt, err)
-				ws.WriteJSON(update)
+		block := event.block
+
+		// A form in block H is in the app hash at H+1, and the
+		// commit for H+1 is in block H+2, so receipts wait a block
+		var waiting []*Receipt
+		for _, receipt := range pending {
+			if block.Height <= receipt.BlockHeight {
+				waiting = append(waiting, receipt)
+				continue
+			}
+			built, err := receipts.Build(m.proxy, HexstrToBytes(receipt.FormID), receipt.BlockHeight)
+			if !writeUpdate(ws, built, err) {
+				// Connection closed
+				return
 			}
-			receipt = nil
 		}
+		pending = waiting
 
 		if len(block.Txs) == 0 {
 			// m.logger.Warn("Block has no txs", "height", block.Height)
@@ -609,48 +949,50 @@ func (m *Manager) Updates(w http.ResponseWriter, req *http.Request) {
 
 		for _, tx := range block.Txs {
 			err = wire.ReadBinaryBytes(tx, &action)
+			if err != nil || action.Type != ActionSubmitForm {
+				continue
+			}
+			err = json.Unmarshal(action.Data, &info)
+			if err != nil {
+				m.logger.Warn("Failed to decode form info", "height", block.Height, "error", err)
+				continue
+			}
+			// Older forms have untyped Ed25519 submitters
+			if PubKeyHexstrEqual(info.Submitter, pubKey) {
+				// Send the receipt once the app hash is committed
+				pending = append(pending, NewReceipt(block.Height+1, info.FormID))
+			}
+			if info.Issue != issue {
+				// Not what we're looking for..
+				continue
+			}
+			b, err := m.node.Blocks.GetBlock(m.node.Context(), info.ContentID)
 			if err == nil {
-				if action.Type != ActionSubmitForm {
-					continue
-				}
-				err = json.Unmarshal(action.Data, &info)
-				if err != nil {
-					panic(err)
-				}
-				if err == nil {
-					if info.Submitter == pubKeystr {
-						// Create new receipt, do not set app hash yet
-						// Once we recv next block we will send receipt
-						receipt = NewReceipt(block.Height, info.FormID)
-					}
-					if info.Issue != issue {
-						// Not what we're looking for..
-						continue
-					}
-					b, err := m.node.Blocks.GetBlock(m.node.Context(), info.ContentID)
-					if err != nil {
-						panic(err)
-					}
-					err = wire.ReadBinaryBytes(b.RawData(), &form)
-					if err != nil {
-						panic(err)
-					}
-					// Send form to feed
-					update, _ := NewUpdate(&form, err)
-					ws.WriteJSON(update)
+				err = wire.ReadBinaryBytes(b.RawData(), &form)
+			}
+			if err != nil {
+				err = errors.Wrapf(err, "Failed to get form %X", info.FormID)
+				if !writeUpdate(ws, nil, err) {
+					return
 				}
+				continue
+			}
+			// Send form to feed
+			if !writeUpdate(ws, &form, nil) {
+				// Connection closed
+				return
 			}
 		}
 	}
 }
 
-func (m *Manager) SearchForms(w http.ResponseWriter, req *http.Request) {
+func (m *Manager) SearchForms(w http.ResponseWriter, req *http.Request, p protocol) {
 
 	// Get values from request body
-	vals, err := UrlValues(req)
+	vals, err := p.values(req)
 
 	if err != nil {
-		http.Error(w, "Failed to read request data", http.StatusBadRequest)
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
 		return
 	}
 
@@ -659,29 +1001,43 @@ func (m *Manager) SearchForms(w http.ResponseWriter, req *http.Request) {
 	before := vals.Get("before")
 
 	// Search
-	s := NewSearch(after, before, issue)
+	s, err := NewSearch(after, before, issue)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
 	query := KeyQuery(wire.BinaryBytes(s), QuerySearch)
 
 	result, err := m.proxy.TMSPQuery(query)
 
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageSearchForms(nil, err))
+		return
 	}
 
 	err = ResultToError(result)
 
 	if err != nil {
-		panic(err)
+		p.respond(w, MessageSearchForms(nil, err))
+		return
 	}
 
-	var datas [][]byte
+	// No data when no forms match
+	formIDs := []string{}
 
-	err = wire.ReadBinaryBytes(result.Result.Data, &datas)
-	if err != nil {
-		panic(err)
+	if len(result.Result.Data) > 0 {
+		var datas [][]byte
+		err = wire.ReadBinaryBytes(result.Result.Data, &datas)
+		if err != nil {
+			p.respond(w, MessageSearchForms(nil, err))
+			return
+		}
+		for _, data := range datas {
+			formIDs = append(formIDs, BytesToHexstr(data))
+		}
 	}
 
-	for _, data := range datas {
-		fmt.Println(BytesToHexstr(data))
-	}
+	p.respond(w, MessageSearchForms(formIDs, nil))
 }
@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	. "github.com/zballs/comit/types"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware for the HTTP server. A panic in one handler
+// is logged and answered with a 500, and doesn't take the
+// server down with it.
+
+// Keeps the status so middleware can see what was written.
+// Hijack is passed through for websocket upgrades.
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(data []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(data)
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+	if sw.status == 0 {
+		sw.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func wrapWriter(w http.ResponseWriter) *statusWriter {
+	if sw, ok := w.(*statusWriter); ok {
+		return sw
+	}
+	return &statusWriter{ResponseWriter: w}
+}
+
+func RecoverHandler(h http.Handler, logger Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := wrapWriter(w)
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			logger.Error("Panic in handler", "method", req.Method,
+				"path", req.URL.Path, "panic", rec, "stack", string(debug.Stack()))
+			if sw.status != 0 {
+				// Too late for an error response
+				return
+			}
+			err := errors.New("Internal server error")
+			if strings.HasPrefix(req.URL.Path, APIPrefix) {
+				apiProtocol{}.fail(sw, http.StatusInternalServerError, err)
+			} else {
+				legacyProtocol{}.fail(sw, http.StatusInternalServerError, err)
+			}
+		}()
+		h.ServeHTTP(sw, req)
+	})
+}
+
+func LogHandler(h http.Handler, logger Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sw := wrapWriter(w)
+		start := time.Now()
+		h.ServeHTTP(sw, req)
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.Info("Request", "method", req.Method, "path", req.URL.Path,
+			"status", status, "duration", time.Since(start))
+	})
+}
@@ -0,0 +1,39 @@
+package manager
+
+import (
+	. "github.com/zballs/comit/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	logger := NewLogger("test")
+	panics := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic("handler panicked")
+	})
+	h := LogHandler(RecoverHandler(panics, logger), logger)
+
+	for _, path := range []string{"/find_form", APIPrefix + "/find_form"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("%v: expected status 500, got %v", path, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", APIPrefix+"/find_form", nil))
+	if !strings.Contains(rec.Body.String(), ErrCodeInternal) {
+		t.Errorf("Expected JSON error body, got %q", rec.Body.String())
+	}
+}
+
+func TestErrorToAPIUnavailable(t *testing.T) {
+	status, apiErr := ErrorToAPI(errNoNode)
+	if status != http.StatusServiceUnavailable || apiErr.Code != ErrCodeUnavailable {
+		t.Errorf("Expected status %v and code %v, got %v and %v",
+			http.StatusServiceUnavailable, ErrCodeUnavailable, status, apiErr.Code)
+	}
+}
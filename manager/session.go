@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	. "github.com/zballs/comit/types"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Each browser gets a session token in a cookie at login. The
+// session holds the user's account, so handlers prepare actions
+// for the caller. Handlers lock the session while they use the
+// account, since the pending sequence changes with every tx.
+
+const (
+	SessionCookie = "comit_session"
+
+	// Sessions expire after this long without a request
+	DefaultSessionTTL = 12 * time.Hour
+)
+
+type Session struct {
+	sync.Mutex
+	acc     *Account
+	expires time.Time
+
+	// Set when the user logged in with the keystore
+	privKey crypto.PrivKey
+	keyName string
+}
+
+func NewSession(acc *Account) *Session {
+	return &Session{acc: acc}
+}
+
+func (sess *Session) Account() *Account {
+	return sess.acc
+}
+
+type SessionStore struct {
+	mtx      sync.Mutex
+	sessions map[string]*Session
+	ttl      time.Duration
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{
+		sessions: make(map[string]*Session),
+		ttl:      ttl,
+	}
+}
+
+func (ss *SessionStore) Create(sess *Session) (string, error) {
+	bz := make([]byte, 32)
+	_, err := rand.Read(bz)
+	if err != nil {
+		return "", errors.Wrap(err, "generating session token")
+	}
+	token := hex.EncodeToString(bz)
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	ss.prune()
+	sess.expires = time.Now().Add(ss.ttl)
+	ss.sessions[token] = sess
+	return token, nil
+}
+
+// Get returns the session for a token and extends it,
+// or nil if there is no such session or it expired
+func (ss *SessionStore) Get(token string) *Session {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	sess, ok := ss.sessions[token]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if now.After(sess.expires) {
+		delete(ss.sessions, token)
+		return nil
+	}
+	sess.expires = now.Add(ss.ttl)
+	return sess
+}
+
+func (ss *SessionStore) Delete(token string) {
+	ss.mtx.Lock()
+	defer ss.mtx.Unlock()
+	delete(ss.sessions, token)
+}
+
+func (ss *SessionStore) prune() {
+	now := time.Now()
+	for token, sess := range ss.sessions {
+		if now.After(sess.expires) {
+			delete(ss.sessions, token)
+		}
+	}
+}
+
+//------------------------------------------------//
+
+func sessionToken(req *http.Request) string {
+	cookie, err := req.Cookie(SessionCookie)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Browsers only send a JSON body or a custom header cross-site
+// after a CORS preflight, which the manager doesn't answer, so
+// state-changing requests without either may be forged
+
+var errCSRF = NewAPIError(ErrCodeForbidden, "Request must be JSON or set the "+CSRFHeader+" header")
+
+func csrf(h handler) handler {
+	return func(w http.ResponseWriter, req *http.Request, p protocol) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			p.fail(w, http.StatusMethodNotAllowed,
+				errors.Errorf("Method %v not allowed; use POST", req.Method))
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mediaType != "application/json" && req.Header.Get(CSRFHeader) == "" {
+			p.fail(w, http.StatusForbidden, errCSRF)
+			return
+		}
+		h(w, req, p)
+	}
+}
+
+// The caller's session, from the request cookie
+func (m *Manager) session(req *http.Request) (*Session, error) {
+	sess := m.sessions.Get(sessionToken(req))
+	if sess == nil {
+		return nil, errors.New("Not logged in")
+	}
+	return sess, nil
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	. "github.com/zballs/comit/types"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	ss := NewSessionStore(time.Hour)
+	alice := &Account{Username: "alice"}
+	bob := &Account{Username: "bob"}
+
+	aliceToken, err := ss.Create(NewSession(alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobToken, err := ss.Create(NewSession(bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliceToken == bobToken {
+		t.Fatal("Expected distinct session tokens")
+	}
+	if sess := ss.Get(aliceToken); sess == nil || sess.Account() != alice {
+		t.Error("Expected alice's session to hold alice's account")
+	}
+	if sess := ss.Get(bobToken); sess == nil || sess.Account() != bob {
+		t.Error("Expected bob's session to hold bob's account")
+	}
+
+	ss.Delete(aliceToken)
+	if ss.Get(aliceToken) != nil {
+		t.Error("Expected deleted session to be gone")
+	}
+	if ss.Get("") != nil {
+		t.Error("Expected no session for an empty token")
+	}
+
+	// Expired sessions are dropped
+	ss.ttl = -time.Second
+	token, _ := ss.Create(NewSession(alice))
+	if ss.Get(token) != nil {
+		t.Error("Expected expired session to be gone")
+	}
+}
+
+func TestSessionCookieSameSite(t *testing.T) {
+	w := httptest.NewRecorder()
+	setSessionCookie(w, "token")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected a SameSite=Strict session cookie, got %v", cookies)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	m := &Manager{}
+	called := false
+	h := m.legacy(csrf(func(w http.ResponseWriter, req *http.Request, p protocol) {
+		called = true
+	}))
+	do := func(method, contentType, header string) int {
+		called = false
+		req := httptest.NewRequest(method, "/logout", strings.NewReader("{}"))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if header != "" {
+			req.Header.Set(CSRFHeader, header)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w.Code
+	}
+	if code := do("GET", "", ""); code != http.StatusMethodNotAllowed || called {
+		t.Errorf("Expected GET to be refused, got %v", code)
+	}
+	if code := do("POST", "application/x-www-form-urlencoded", ""); code != http.StatusForbidden || called {
+		t.Errorf("Expected a form post without the header to be refused, got %v", code)
+	}
+	if code := do("POST", "text/plain", ""); code != http.StatusForbidden || called {
+		t.Errorf("Expected a plain text post to be refused, got %v", code)
+	}
+	if do("POST", "application/json; charset=utf-8", ""); !called {
+		t.Error("Expected a JSON post to be allowed")
+	}
+	if do("POST", "application/x-www-form-urlencoded", "XMLHttpRequest"); !called {
+		t.Error("Expected a form post with the header to be allowed")
+	}
+}
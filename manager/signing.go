@@ -0,0 +1,331 @@
+package manager
+
+import (
+	"crypto/rand"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// By default the manager never holds a private key. Handlers
+// prepare actions and return their sign bytes; /broadcast takes
+// the client's signature, checks it and broadcasts the action.
+// Logins sign a one-time challenge in the same way. Sessions
+// that logged in with the keystore are signed for right away.
+
+var errInvalidSignature = NewAPIError(ErrCodeInvalidSignature, "Invalid signature")
+
+// Prepared actions and challenges expire after this long
+const PendingTTL = 5 * time.Minute
+
+// Most prepared actions and challenges held at once
+const MaxPending = 10000
+
+var errPendingFull = NewAPIError(ErrCodeUnavailable, "Too many pending requests; try again later")
+
+type pendingItem struct {
+	v       interface{}
+	expires time.Time
+}
+
+type PendingStore struct {
+	mtx       sync.Mutex
+	items     map[string]*pendingItem
+	ttl       time.Duration
+	max       int
+	nextSweep time.Time
+}
+
+func NewPendingStore(ttl time.Duration, max int) *PendingStore {
+	return &PendingStore{
+		items: make(map[string]*pendingItem),
+		ttl:   ttl,
+		max:   max,
+	}
+}
+
+// Expired items are swept at most every tenth of the TTL,
+// so a full store doesn't make every Put scan it
+
+func (ps *PendingStore) Put(id string, v interface{}) error {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	now := time.Now()
+	if now.After(ps.nextSweep) {
+		ps.sweep(now)
+		ps.nextSweep = now.Add(ps.ttl / 10)
+	}
+	if len(ps.items) >= ps.max {
+		return errPendingFull
+	}
+	ps.items[id] = &pendingItem{v, now.Add(ps.ttl)}
+	return nil
+}
+
+func (ps *PendingStore) sweep(now time.Time) {
+	for id, item := range ps.items {
+		if now.After(item.expires) {
+			delete(ps.items, id)
+		}
+	}
+}
+
+// Take removes the item, so it can only be used once
+func (ps *PendingStore) Take(id string) interface{} {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	item, ok := ps.items[id]
+	if !ok {
+		return nil
+	}
+	delete(ps.items, id)
+	if time.Now().After(item.expires) {
+		return nil
+	}
+	return item.v
+}
+
+//------------------------------------------------//
+
+type preparedAction struct {
+	action Action
+	pubKey crypto.PubKey
+	name   string
+
+	// Session the action was prepared in, if any,
+	// and the response data once it is broadcast
+	token string
+	data  interface{}
+}
+
+// Caller holds the session lock, if any
+func (m *Manager) prepareAction(w http.ResponseWriter, p protocol, prepared *preparedAction, sess *Session) {
+	if sess != nil && sess.privKey != nil {
+		sig := sess.privKey.Sign(prepared.action.SignBytes(m.getChainID()))
+		m.broadcastAction(w, p, prepared, sig, sess)
+		return
+	}
+	unsigned := NewUnsignedAction(prepared.action, m.getChainID())
+	err := m.pending.Put(unsigned.ID, prepared)
+	if err != nil {
+		p.fail(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	p.respond(w, MessageSign(prepared.name, unsigned, nil))
+}
+
+func (m *Manager) Broadcast(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	// Get request data
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	prepared, ok := m.pending.Take(vals.Get("id")).(*preparedAction)
+
+	if !ok {
+		p.fail(w, http.StatusBadRequest, errors.New("Unknown or expired action"))
+		return
+	}
+
+	sig, err := SignaturefromHexstr(vals.Get("signature"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Hold the session until the sequence is updated
+	var sess *Session
+
+	if prepared.token != "" {
+		sess = m.sessions.Get(prepared.token)
+		if sess == nil || prepared.token != sessionToken(req) {
+			p.fail(w, http.StatusUnauthorized, errors.New("Not logged in"))
+			return
+		}
+		sess.Lock()
+		defer sess.Unlock()
+	}
+
+	m.broadcastAction(w, p, prepared, sig, sess)
+}
+
+// Checks the signature, broadcasts and writes the response.
+// Caller holds the session lock, if any.
+
+func (m *Manager) broadcastAction(w http.ResponseWriter, p protocol, prepared *preparedAction, sig crypto.Signature, sess *Session) error {
+
+	action := prepared.action
+
+	if !prepared.pubKey.VerifyBytes(action.SignBytes(m.getChainID()), sig) {
+		p.fail(w, http.StatusUnauthorized, errInvalidSignature)
+		return errInvalidSignature
+	}
+
+	action.Input.Signature = sig
+
+	// Broadcast tx
+	result, err := m.proxy.BroadcastTx("sync", action.Tx())
+
+	if err == nil {
+		err = ResultToError(result)
+	}
+
+	if sess != nil {
+		if err != nil {
+			// Our sequence may be stale
+			m.SyncSequence(sess.Account())
+		} else {
+			// CheckTx is ok so the app has recorded the
+			// pending sequence; the next tx can follow it
+			sess.Account().Sequence = action.Input.Sequence
+		}
+	}
+
+	if err == nil && action.Type == ActionRemoveAccount {
+		// Account is gone, so are the session and stored key
+		m.sessions.Delete(prepared.token)
+		clearSessionCookie(w)
+		if sess != nil && sess.keyName != "" {
+			m.keystore.Delete(sess.keyName)
+		}
+	}
+
+	data := prepared.data
+
+	if err != nil {
+		data = nil
+	}
+
+	p.respond(w, &Message{
+		Action: prepared.name,
+		Data:   data,
+		Error:  err,
+	})
+
+	return err
+}
+
+// Login challenge
+
+const ChallengeLength = 32
+
+// Challenges a client address can request per window
+const (
+	ChallengeLimit  = 20
+	ChallengeWindow = time.Minute
+)
+
+var errTooManyChallenges = NewAPIError(ErrCodeTooManyRequests, "Too many login challenges; try again later")
+
+// Counts are reset every window, so the limiter
+// only holds the clients seen in the current one
+
+type rateLimiter struct {
+	mtx    sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	counts map[string]int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]int),
+	}
+}
+
+func (rl *rateLimiter) Allow(key string) bool {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	now := time.Now()
+	if now.Sub(rl.start) >= rl.window {
+		rl.start = now
+		rl.counts = make(map[string]int)
+	}
+	if rl.counts[key] >= rl.limit {
+		return false
+	}
+	rl.counts[key]++
+	return true
+}
+
+// Forwarding headers can be set by the client, so
+// the limit applies to the connection's address
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (m *Manager) LoginChallenge(w http.ResponseWriter, req *http.Request, p protocol) {
+
+	if !m.challenges.Allow(remoteHost(req)) {
+		p.fail(w, http.StatusTooManyRequests, errTooManyChallenges)
+		return
+	}
+
+	// Get request data
+	vals, err := p.values(req)
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, errors.Wrap(err, "Failed to read request data"))
+		return
+	}
+
+	pubKey, err := PubKeyfromHexstr(vals.Get("pub_key"))
+
+	if err != nil {
+		p.fail(w, http.StatusBadRequest, err)
+		return
+	}
+
+	challenge := make([]byte, ChallengeLength)
+	_, err = rand.Read(challenge)
+
+	if err != nil {
+		p.respond(w, MessageLoginChallenge("", err))
+		return
+	}
+
+	// Challenge is only good for this key
+	challengestr := BytesToHexstr(challenge)
+	err = m.pending.Put("login/"+challengestr, pubKey)
+
+	if err != nil {
+		p.fail(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	p.respond(w, MessageLoginChallenge(challengestr, nil))
+}
+
+func (m *Manager) checkChallenge(pubKey crypto.PubKey, challengestr, sigstr string) error {
+	challengeKey, ok := m.pending.Take("login/" + challengestr).(crypto.PubKey)
+	if !ok || !challengeKey.Equals(pubKey) {
+		return errors.New("Unknown or expired challenge")
+	}
+	sig, err := SignaturefromHexstr(sigstr)
+	if err != nil {
+		return err
+	}
+	challenge := HexstrToBytes(challengestr)
+	if !pubKey.VerifyBytes(LoginSignBytes(m.getChainID(), challenge), sig) {
+		return errInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"github.com/tendermint/go-crypto"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckChallenge(t *testing.T) {
+	m := &Manager{
+		pending: NewPendingStore(time.Minute, MaxPending),
+		chainID: "test_chain",
+	}
+	privKey := crypto.GenPrivKeyEd25519()
+	pubKey := privKey.PubKey()
+	other := crypto.GenPrivKeyEd25519().PubKey()
+
+	newChallenge := func(pubKey crypto.PubKey) string {
+		challenge := BytesToHexstr(crypto.CRandBytes(ChallengeLength))
+		m.pending.Put("login/"+challenge, pubKey)
+		return challenge
+	}
+	sign := func(challenge string) string {
+		sig := privKey.Sign(LoginSignBytes(m.chainID, HexstrToBytes(challenge)))
+		return SignaturetoHexstr(sig)
+	}
+
+	challenge := newChallenge(pubKey)
+	if err := m.checkChallenge(pubKey, challenge, sign(challenge)); err != nil {
+		t.Fatal(err)
+	}
+	if m.checkChallenge(pubKey, challenge, sign(challenge)) == nil {
+		t.Error("Expected challenge to be used only once")
+	}
+
+	challenge = newChallenge(other)
+	if m.checkChallenge(pubKey, challenge, sign(challenge)) == nil {
+		t.Error("Expected challenge for another key to be rejected")
+	}
+
+	challenge = newChallenge(pubKey)
+	if m.checkChallenge(pubKey, challenge, sign(newChallenge(pubKey))) == nil {
+		t.Error("Expected signature on another challenge to be rejected")
+	}
+}
+
+func TestPendingStoreLimits(t *testing.T) {
+	ps := NewPendingStore(time.Minute, 2)
+	for _, id := range []string{"a", "b"} {
+		if err := ps.Put(id, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ps.Put("c", "c") == nil {
+		t.Error("Expected full store to reject items")
+	}
+	if ps.Take("a") != "a" {
+		t.Error("Expected item a")
+	}
+	if err := ps.Put("c", "c"); err != nil {
+		t.Error(err)
+	}
+
+	// Expired items are swept once the sweep is due
+	ps = NewPendingStore(time.Millisecond, 2)
+	ps.Put("a", "a")
+	ps.Put("b", "b")
+	time.Sleep(2 * time.Millisecond)
+	if err := ps.Put("c", "c"); err != nil {
+		t.Errorf("Expected expired items to be swept: %v", err)
+	}
+	if len(ps.items) != 1 {
+		t.Errorf("Expected 1 item after sweep, got %d", len(ps.items))
+	}
+}
+
+func TestLoginChallengeRateLimit(t *testing.T) {
+	m := &Manager{
+		pending:    NewPendingStore(time.Minute, MaxPending),
+		challenges: newRateLimiter(2, time.Minute),
+	}
+	pubKey := PubKeytoHexstr(crypto.GenPrivKeyEd25519().PubKey())
+	challenge := func(remoteAddr string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"pub_key": "`+pubKey+`"}`))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		m.api("POST", m.LoginChallenge)(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < 2; i++ {
+		if status := challenge("10.0.0.1:1234"); status != http.StatusOK {
+			t.Fatalf("Expected challenge %d to succeed, got %d", i, status)
+		}
+	}
+	if status := challenge("10.0.0.1:5678"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected too many requests, got %d", status)
+	}
+	if status := challenge("10.0.0.2:1234"); status != http.StatusOK {
+		t.Errorf("Expected another client to get a challenge, got %d", status)
+	}
+}
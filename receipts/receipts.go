@@ -0,0 +1,219 @@
+package receipts
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-merkle"
+	"github.com/tendermint/go-wire"
+	tndr "github.com/tendermint/tendermint/types"
+	"github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io/ioutil"
+)
+
+// Package receipts builds receipts that a citizen can check with
+// no network access, against validators they already trust. A receipt for a form committed in block H has
+// the header at H+1, whose app hash covers the state after H, and
+// the commit for H+1 from block H+2. Build waits on neither; it
+// fails until the node has committed H+2.
+
+// Build makes a receipt for a form in the header at height
+func Build(proxy *Proxy, formID []byte, height int) (*Receipt, error) {
+	receipt := NewReceipt(height, formID)
+	headerBlock, err := proxy.GetBlock(height)
+	if err != nil {
+		return receipt, errors.Wrap(err, "getting header")
+	}
+	commitBlock, err := proxy.GetBlock(height + 1)
+	if err != nil {
+		return receipt, errors.Wrap(err, "getting commit")
+	}
+	validators, err := proxy.GetValidators()
+	if err != nil {
+		return receipt, errors.Wrap(err, "getting validators")
+	}
+	key := state.FormKey(formID)
+	proofData, err := query(proxy, HeightQuery(height-1, KeyQuery(key, QueryProof)))
+	if err != nil {
+		return receipt, errors.Wrap(err, "querying proof")
+	}
+	proof := &merkle.IAVLProof{}
+	err = wire.ReadBinaryBytes(proofData, proof)
+	if err != nil {
+		return receipt, errors.Wrap(err, "decoding proof")
+	}
+	value, err := query(proxy, HeightQuery(height-1, KeyQuery(key, QueryValue)))
+	if err != nil {
+		return receipt, errors.Wrap(err, "querying form")
+	}
+	header := headerBlock.Block.Header
+	receipt.AppHash = header.AppHash
+	receipt.Proof = BytesToHexstr(wire.BinaryBytes(&ReceiptProof{
+		Value:  value,
+		Proof:  proof,
+		Header: header,
+		Commit: commitBlock.Block.LastCommit,
+	}))
+	// Don't hand out a receipt that won't verify
+	// against the node's current validators
+	_, err = Verify(receipt, &Trust{header.ChainID, validators.Validators})
+	return receipt, err
+}
+
+func query(proxy *Proxy, query []byte) ([]byte, error) {
+	result, err := proxy.TMSPQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	err = ResultToError(result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Result.Data, nil
+}
+
+// Trust is what a receipt is checked against: a chain ID and
+// validator set the citizen already trusts, e.g. from the chain's
+// genesis file. Everything in the receipt is checked against it,
+// so a receipt signed by any other set does not verify. A set
+// that changed since genesis has to be given as validators.
+
+type Trust struct {
+	ChainID    string
+	Validators []*tndr.Validator
+}
+
+// Verify checks a receipt with only what it carries and the
+// trusted set: the form is in the app hash, the app hash is in
+// the header, and the trusted validators signed the header. It
+// returns the decoded proof, e.g. for the header's time.
+
+func Verify(receipt *Receipt, trust *Trust) (*ReceiptProof, error) {
+	if trust == nil || trust.ChainID == "" || len(trust.Validators) == 0 {
+		return nil, errors.New("No trusted chain ID and validators to verify against")
+	}
+	if receipt.Proof == "" {
+		return nil, errors.New("Receipt has no proof")
+	}
+	formID := HexstrToBytes(receipt.FormID)
+	if len(formID) != FORM_ID_LENGTH {
+		return nil, errors.New("Invalid form ID")
+	}
+	data := HexstrToBytes(receipt.Proof)
+	if len(data) == 0 {
+		return nil, errors.New("Invalid proof hex")
+	}
+	rp := &ReceiptProof{}
+	err := wire.ReadBinaryBytes(data, rp)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding proof")
+	}
+	if rp.Proof == nil || rp.Header == nil || rp.Commit == nil {
+		return nil, errors.New("Incomplete proof")
+	}
+	header := rp.Header
+	if header.ChainID != trust.ChainID {
+		return nil, errors.Errorf("Receipt is for chain %v, not the trusted chain %v",
+			header.ChainID, trust.ChainID)
+	}
+	if header.Height != receipt.BlockHeight {
+		return nil, errors.Errorf("Header height %d does not match receipt height %d",
+			header.Height, receipt.BlockHeight)
+	}
+	if !bytes.Equal(header.AppHash, receipt.AppHash) {
+		return nil, errors.Errorf("Header app hash %X does not match receipt app hash %X",
+			header.AppHash, receipt.AppHash)
+	}
+	if !rp.Proof.Verify(state.FormKey(formID), rp.Value, header.AppHash) {
+		return nil, errors.New("Form is not in the state with this app hash")
+	}
+	if !bytes.Equal(rp.Commit.BlockID.Hash, header.Hash()) {
+		return nil, errors.New("Commit is not for this header")
+	}
+	valSet := tndr.NewValidatorSet(trust.Validators)
+	if !bytes.Equal(valSet.Hash(), header.ValidatorsHash) {
+		return nil, errors.New("Header is not from the trusted validators")
+	}
+	err = valSet.VerifyCommit(header.ChainID, rp.Commit.BlockID, header.Height, rp.Commit)
+	if err != nil {
+		return nil, errors.Wrap(err, "verifying commit")
+	}
+	return rp, nil
+}
+
+// Reads the chain ID and validators from a tendermint genesis file
+func LoadGenesis(path string) (*Trust, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var gen *tndr.GenesisDoc
+	wire.ReadJSONPtr(&gen, data, &err)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding genesis")
+	}
+	trust := &Trust{ChainID: gen.ChainID}
+	for _, val := range gen.Validators {
+		trust.Validators = append(trust.Validators, &tndr.Validator{
+			Address:     val.PubKey.Address(),
+			PubKey:      val.PubKey,
+			VotingPower: val.Amount,
+		})
+	}
+	return trust, nil
+}
+
+// Reads validators as listed by the node's validators RPC,
+// for a set that changed since genesis
+func LoadValidators(path, chainID string) (*Trust, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vals []*tndr.Validator
+	wire.ReadJSONPtr(&vals, data, &err)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding validators")
+	}
+	return &Trust{chainID, vals}, nil
+}
+
+// LoadTrust reads the genesis file, or else the
+// validators file with the chain ID, for the CLIs
+
+func LoadTrust(genesisPath, validatorsPath, chainID string) (*Trust, error) {
+	switch {
+	case genesisPath != "":
+		return LoadGenesis(genesisPath)
+	case validatorsPath != "" && chainID != "":
+		return LoadValidators(validatorsPath, chainID)
+	case validatorsPath != "":
+		return nil, errors.New("A validators file needs a chain ID")
+	}
+	return nil, errors.New("Need a genesis file, or a validators file and chain ID, to trust")
+}
+
+// Receipt files are the receipt's JSON, as sent in an updates feed
+
+func Load(path string) (*Receipt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	receipt := &Receipt{}
+	err = json.Unmarshal(data, receipt)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding receipt")
+	}
+	return receipt, nil
+}
+
+func Save(path string, receipt *Receipt) error {
+	data, err := json.MarshalIndent(receipt, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,146 @@
+package receipts
+
+import (
+	"github.com/tendermint/go-merkle"
+	"github.com/tendermint/go-wire"
+	tndr "github.com/tendermint/tendermint/types"
+	"github.com/zballs/comit/state"
+	. "github.com/zballs/comit/types"
+	. "github.com/zballs/comit/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const chainID = "test_chain"
+
+// A receipt for a form in a tree, with a header for the tree's
+// hash signed by all of a new validator set, and that set to trust
+func testReceipt(t *testing.T) (*Receipt, *ReceiptProof, *Trust) {
+	formID := make([]byte, FORM_ID_LENGTH)
+	formID[0] = 1
+	key := state.FormKey(formID)
+	value := []byte(`"QmContentID"`)
+
+	tree := merkle.NewIAVLTree(0, nil)
+	tree.Set(key, value)
+	tree.Set(state.FormKey(make([]byte, FORM_ID_LENGTH)), []byte(`"QmOther"`))
+
+	valSet, privVals := tndr.RandValidatorSet(4, 10)
+	header := &tndr.Header{
+		ChainID:        chainID,
+		Height:         3,
+		AppHash:        tree.Hash(),
+		ValidatorsHash: valSet.Hash(),
+	}
+	blockID := tndr.BlockID{Hash: header.Hash()}
+	commit := &tndr.Commit{BlockID: blockID}
+	for i, privVal := range privVals {
+		vote := &tndr.Vote{
+			ValidatorAddress: privVal.Address,
+			ValidatorIndex:   i,
+			Height:           header.Height,
+			Type:             tndr.VoteTypePrecommit,
+			BlockID:          blockID,
+		}
+		vote.Signature = privVal.PrivKey.Sign(tndr.SignBytes(chainID, vote))
+		commit.Precommits = append(commit.Precommits, vote)
+	}
+
+	rp := &ReceiptProof{
+		Value:  value,
+		Proof:  tree.ConstructProof(key),
+		Header: header,
+		Commit: commit,
+	}
+	receipt := NewReceipt(header.Height, formID)
+	receipt.AppHash = header.AppHash
+	receipt.Proof = BytesToHexstr(wire.BinaryBytes(rp))
+	return receipt, rp, &Trust{chainID, valSet.Validators}
+}
+
+func TestVerify(t *testing.T) {
+	receipt, _, trust := testReceipt(t)
+	rp, err := Verify(receipt, trust)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Header.ChainID != chainID {
+		t.Errorf("Expected chain ID %v, got %v", chainID, rp.Header.ChainID)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := map[string]func(*Receipt, *ReceiptProof){
+		"no proof": func(r *Receipt, rp *ReceiptProof) {
+			r.Proof = ""
+		},
+		"other form": func(r *Receipt, rp *ReceiptProof) {
+			r.FormID = BytesToHexstr(make([]byte, FORM_ID_LENGTH))
+		},
+		"other value": func(r *Receipt, rp *ReceiptProof) {
+			rp.Value = []byte(`"QmForged"`)
+		},
+		"other app hash": func(r *Receipt, rp *ReceiptProof) {
+			r.AppHash = make([]byte, len(r.AppHash))
+		},
+		"other height": func(r *Receipt, rp *ReceiptProof) {
+			r.BlockHeight++
+		},
+		"other header": func(r *Receipt, rp *ReceiptProof) {
+			rp.Header.NumTxs++
+		},
+		"too few signatures": func(r *Receipt, rp *ReceiptProof) {
+			rp.Commit.Precommits[0] = nil
+			rp.Commit.Precommits[1] = nil
+		},
+	}
+	for name, tamper := range tests {
+		receipt, rp, trust := testReceipt(t)
+		tamper(receipt, rp)
+		if receipt.Proof != "" {
+			receipt.Proof = BytesToHexstr(wire.BinaryBytes(rp))
+		}
+		if _, err := Verify(receipt, trust); err == nil {
+			t.Errorf("%v: expected receipt not to verify", name)
+		}
+	}
+}
+
+// A receipt that is consistent in itself, but signed by
+// validators other than the trusted ones, or for another chain
+func TestVerifyUntrusted(t *testing.T) {
+	receipt, _, trust := testReceipt(t)
+
+	valSet, _ := tndr.RandValidatorSet(4, 10)
+	if _, err := Verify(receipt, &Trust{chainID, valSet.Validators}); err == nil {
+		t.Error("Expected receipt signed by an untrusted set not to verify")
+	}
+	if _, err := Verify(receipt, &Trust{"other_chain", trust.Validators}); err == nil {
+		t.Error("Expected receipt for another chain not to verify")
+	}
+	if _, err := Verify(receipt, nil); err == nil {
+		t.Error("Expected receipt not to verify with nothing to trust")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	receipt, _, trust := testReceipt(t)
+	dir, err := ioutil.TempDir("", "receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "receipt.json")
+	if err = Save(path, receipt); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(loaded, trust); err != nil {
+		t.Fatal(err)
+	}
+}
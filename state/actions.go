@@ -0,0 +1,76 @@
+package state
+
+import (
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+)
+
+// Core actions. remove_account is not batchable, since
+// later items in the batch would write the account back.
+
+func init() {
+	RegisterAction(&ActionHandler{
+		Type:           ActionCreateAccount,
+		Name:           "create_account",
+		Decode:         decodeUsername,
+		Run:            runCreateAccount,
+		CreatesAccount: true,
+	})
+	RegisterAction(&ActionHandler{
+		Type:   ActionRemoveAccount,
+		Name:   "remove_account",
+		Decode: decodeNothing,
+		Run:    runRemoveAccount,
+	})
+	RegisterAction(&ActionHandler{
+		Type:      ActionSubmitForm,
+		Name:      "submit_form",
+		Decode:    decodeInfo,
+		Run:       runSubmitForm,
+		Batchable: true,
+	})
+	RegisterAction(&ActionHandler{
+		Type:     ActionBatch,
+		Name:     "batch",
+		Decode:   decodeBatch,
+		Validate: validateBatch,
+		Run:      runBatch,
+	})
+}
+
+// Username is optional
+func decodeUsername(data []byte) (interface{}, error) {
+	username, _, err := wire.GetByteSlice(data)
+	if err != nil {
+		return "", nil
+	}
+	return string(username), nil
+}
+
+func decodeNothing(data []byte) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeBatch(data []byte) (interface{}, error) {
+	var items []BatchItem
+	err := wire.ReadBinaryBytes(data, &items)
+	return items, err
+}
+
+func runCreateAccount(state *State, acc *Account, v interface{}) tmsp.Result {
+	acc.Username = v.(string)
+	return RunCreateAccount(state, acc)
+}
+
+func runRemoveAccount(state *State, acc *Account, v interface{}) tmsp.Result {
+	return RunRemoveAccount(state, acc)
+}
+
+func runSubmitForm(state *State, acc *Account, v interface{}) tmsp.Result {
+	return RunSubmitForm(state, acc, v.(Info))
+}
+
+func runBatch(state *State, acc *Account, v interface{}) tmsp.Result {
+	return RunBatch(state, acc, v.([]BatchItem))
+}
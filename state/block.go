@@ -0,0 +1,288 @@
+package state
+
+import (
+	"encoding/binary"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	"github.com/zballs/comit/types"
+	"sort"
+	"time"
+)
+
+// TMSP does not pass the block header to the app, so block time
+// is derived from the client timestamps of forms in the block:
+// the median, never earlier than the previous block time. Every
+// validator computes the same value from the same txs.
+//
+// Block time starts at the genesis time, and advances at most
+// MaxBlockAdvance for each block since it was last set. Forms
+// with timestamps past that bound, plus MaxClockDrift, are
+// rejected in AppendTx, and forms more than MaxClockDrift before
+// block time are rejected too. A proposer that fills blocks with
+// its own timestamps can still run block time ahead of the clock
+// by up to MaxBlockAdvance per block; CheckTx holds honest nodes
+// to their local clocks, but nothing stronger is enforced.
+
+// Max seconds a client timestamp can be off
+const MaxClockDrift = 10 * 60
+
+// Max seconds block time can advance per block
+const MaxBlockAdvance = MaxClockDrift
+
+const (
+	FormMetaPrefix    = "base/m/"
+	FormTimePrefix    = "base/t/"
+	PendingFormPrefix = "base/p/"
+	BlockTimeKey      = "base/time"
+
+	// Height at which block time was last set
+	BlockTimeHeightKey = "base/time_height"
+)
+
+func FormMetaKey(formID []byte) []byte {
+	return append([]byte(FormMetaPrefix), formID...)
+}
+
+// Time index keys sort by block time, then form ID
+func FormTimeKey(t int64, formID []byte) []byte {
+	key := append([]byte(FormTimePrefix), timeBytes(t)...)
+	return append(key, formID...)
+}
+
+func FormTimeRange(after, before int64) (start, end []byte) {
+	start = append([]byte(FormTimePrefix), timeBytes(after)...)
+	end = append([]byte(FormTimePrefix), timeBytes(before)...)
+	return start, end
+}
+
+func PendingFormKey(formID []byte) []byte {
+	return append([]byte(PendingFormPrefix), formID...)
+}
+
+func timeBytes(t int64) []byte {
+	if t < 0 {
+		t = 0
+	}
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, uint64(t))
+	return bz
+}
+
+// Height of the block being executed
+
+func (s *State) SetBlockHeight(height int) {
+	s.height = height
+}
+
+func (s *State) GetBlockHeight() int {
+	return s.height
+}
+
+func (s *State) GetBlockTime() int64 {
+	data := s.Get([]byte(BlockTimeKey))
+	if len(data) == 0 {
+		return 0
+	}
+	var t int64
+	err := wire.ReadBinaryBytes(data, &t)
+	if err != nil {
+		PanicSanity("Error reading block time: " + err.Error())
+	}
+	return t
+}
+
+func (s *State) getBlockTimeHeight() int {
+	data := s.Get([]byte(BlockTimeHeightKey))
+	if len(data) == 0 {
+		return 0
+	}
+	var height int
+	err := wire.ReadBinaryBytes(data, &height)
+	if err != nil {
+		PanicSanity("Error reading block time height: " + err.Error())
+	}
+	return height
+}
+
+func (s *State) setBlockTime(t int64, height int) {
+	s.Set([]byte(BlockTimeKey), wire.BinaryBytes(t))
+	s.Set([]byte(BlockTimeHeightKey), wire.BinaryBytes(height))
+}
+
+// SetGenesisTime starts block time at the chain's genesis
+// time, so the first block is bounded like the others
+
+func (s *State) SetGenesisTime(t int64) {
+	s.setBlockTime(t, 0)
+}
+
+// MaxBlockTime is the latest time the block being executed can have
+func (s *State) MaxBlockTime() int64 {
+	blocks := s.GetBlockHeight() - s.getBlockTimeHeight()
+	if blocks < 1 {
+		blocks = 1
+	}
+	return s.GetBlockTime() + int64(blocks)*MaxBlockAdvance
+}
+
+// RebaseHeights moves state recorded at the heights of another
+// chain, e.g. in a snapshot taken at height, onto a chain that
+// starts at height 1. Upgrades keep their distance from height,
+// block time carries over, and forms keep their block time but
+// get height 0, since they were committed before the chain began.
+
+func (s *State) RebaseHeights(height int) error {
+	s.rebaseUpgrades(height)
+	if s.GetBlockTime() > 0 {
+		s.setBlockTime(s.GetBlockTime(), 0)
+	}
+	var formIDs [][]byte
+	prefix := []byte(FormMetaPrefix)
+	_, err := types.IteratePrefix(s, prefix, func(key, _ []byte) bool {
+		formIDs = append(formIDs, key[len(prefix):])
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	for _, formID := range formIDs {
+		meta := s.GetFormMeta(formID)
+		meta.Height = 0
+		s.Set(FormMetaKey(formID), wire.BinaryBytes(meta))
+	}
+	return nil
+}
+
+func (s *State) GetFormMeta(formID []byte) *types.FormMeta {
+	data := s.Get(FormMetaKey(formID))
+	if len(data) == 0 {
+		return nil
+	}
+	var meta *types.FormMeta
+	err := wire.ReadBinaryBytes(data, &meta)
+	if err != nil {
+		PanicSanity("Error reading form meta: " + err.Error())
+	}
+	return meta
+}
+
+// Deterministic check, run in AppendTx
+func checkSubmittedAt(state *State, submittedAt int64) tmsp.Result {
+	blockTime := state.GetBlockTime()
+	if blockTime == 0 {
+		return tmsp.ErrInternalError.SetLog("Block time is not set, genesis time is required")
+	}
+	if submittedAt < blockTime-MaxClockDrift {
+		return tmsp.ErrBaseInvalidInput.SetLog(
+			Fmt("Submission time %v is before last block time %v", submittedAt, blockTime))
+	}
+	if maxTime := state.MaxBlockTime(); submittedAt > maxTime+MaxClockDrift {
+		return tmsp.ErrBaseInvalidInput.SetLog(
+			Fmt("Submission time %v is after max block time %v", submittedAt, maxTime))
+	}
+	return tmsp.OK
+}
+
+// CheckActionTime checks client timestamps against the local
+// clock. It is only run in CheckTx, since clocks differ;
+// AppendTx checks them against block time instead.
+
+func CheckActionTime(action types.Action, now time.Time) tmsp.Result {
+	switch action.Type {
+	case types.ActionSubmitForm:
+		return checkFormTime(action.Data, now)
+	case types.ActionBatch:
+		var items []types.BatchItem
+		err := wire.ReadBinaryBytes(action.Data, &items)
+		if err != nil {
+			return tmsp.ErrEncodingError.SetLog("Failed to decode batch items")
+		}
+		for _, item := range items {
+			if item.Type != types.ActionSubmitForm {
+				continue
+			}
+			res := checkFormTime(item.Data, now)
+			if res.IsErr() {
+				return res
+			}
+		}
+	}
+	return tmsp.OK
+}
+
+func checkFormTime(data []byte, now time.Time) tmsp.Result {
+	v, err := decodeInfo(data)
+	if err != nil {
+		return tmsp.ErrEncodingError.SetLog("Failed to decode data")
+	}
+	info := v.(types.Info)
+	drift := info.SubmittedAt - now.Unix()
+	if drift > MaxClockDrift || drift < -MaxClockDrift {
+		return tmsp.ErrBaseInvalidInput.SetLog(
+			Fmt("Submission time %v is out of bounds", info.SubmittedAt))
+	}
+	return tmsp.OK
+}
+
+// EndBlock records the forms submitted in the block and
+// returns the validator diffs staged in the block
+
+func EndBlock(state *State) []*tmsp.Validator {
+	recordForms(state)
+	return validatorDiffs(state)
+}
+
+// Sets the block time and records it with the block
+// height on every form submitted in the block
+
+func recordForms(state *State) {
+	var formIDs [][]byte
+	var times []int64
+	prefix := []byte(PendingFormPrefix)
+	_, err := types.IteratePrefix(state, prefix, func(key, value []byte) bool {
+		var t int64
+		err := wire.ReadBinaryBytes(value, &t)
+		if err != nil {
+			PanicSanity("Error reading pending form time: " + err.Error())
+		}
+		formIDs = append(formIDs, key[len(prefix):])
+		times = append(times, t)
+		return false
+	})
+	if err != nil {
+		PanicSanity("Error reading pending forms: " + err.Error())
+	}
+	if len(formIDs) == 0 {
+		return
+	}
+	blockTime := state.GetBlockTime()
+	maxTime := state.MaxBlockTime()
+	median := medianTime(times)
+	switch {
+	case median > maxTime:
+		blockTime = maxTime
+	case median > blockTime:
+		blockTime = median
+	}
+	state.setBlockTime(blockTime, state.GetBlockHeight())
+	meta := &types.FormMeta{state.GetBlockHeight(), blockTime}
+	for _, formID := range formIDs {
+		state.Set(PendingFormKey(formID), nil)
+		state.Set(FormMetaKey(formID), wire.BinaryBytes(meta))
+		state.Set(FormTimeKey(blockTime, formID), formID)
+	}
+}
+
+func medianTime(times []int64) int64 {
+	sorted := make([]int64, len(times))
+	copy(sorted, times)
+	sort.Sort(int64s(sorted))
+	return sorted[len(sorted)/2]
+}
+
+type int64s []int64
+
+func (is int64s) Len() int           { return len(is) }
+func (is int64s) Less(i, j int) bool { return is[i] < is[j] }
+func (is int64s) Swap(i, j int)      { is[i], is[j] = is[j], is[i] }
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"bytes"
 	"encoding/json"
 	. "github.com/tendermint/go-common"
 	"github.com/tendermint/go-wire"
@@ -22,26 +23,55 @@ func ExecuteAction(state *State, action Action, isCheckTx bool) (res tmsp.Result
 		return res
 	}
 
+	// Check expiry against the height of the block
+	// being executed, or the next block in CheckTx
+	height := state.GetBlockHeight()
+	if action.Input.Expired(height) {
+		return tmsp.ErrBaseInvalidInput.AppendLog(
+			Fmt("Action expired at height %v (height=%v)", action.Input.ValidUntil, height))
+	}
+
+	handler, res := getHandler(state, action.Type)
+	if res.IsErr() {
+		return res
+	}
+
+	// Decode and validate data
+	v, res := handler.decode(action.Data)
+	if res.IsErr() {
+		return res
+	}
+
 	var acc *Account
 
-	if action.Type == ActionCreateAccount {
+	if handler.CreatesAccount {
 		// Create new account // Must have input pubKey
-		var username string
-		data, _, err := wire.GetByteSlice(action.Data)
-		if err == nil {
-			username = string(data)
-		} else {
-			// No username
+		acc = NewAccount(action.Input.PubKey, "")
+		if !bytes.Equal(acc.PubKey.Address(), action.Input.Address) {
+			return tmsp.ErrBaseInvalidInput.AppendLog("Address does not match PubKey")
+		}
+		// Creating over an existing account would reset it
+		if state.GetAccount(action.Input.Address) != nil {
+			return tmsp.ErrBaseInvalidInput.AppendLog("Account exists")
 		}
-		acc = NewAccount(action.Input.PubKey, username)
 	} else {
 		// Get input account
 		acc = state.GetAccount(action.Input.Address)
 		if acc == nil {
 			return tmsp.ErrBaseUnknownAddress
 		}
+		// The input key is only taken for an account stored
+		// without one, and never replaces an existing key
 		if action.Input.PubKey != nil {
-			acc.PubKey = action.Input.PubKey
+			if !bytes.Equal(action.Input.PubKey.Address(), action.Input.Address) {
+				return tmsp.ErrBaseInvalidPubKey.AppendLog("Address does not match PubKey")
+			}
+			if acc.PubKey == nil {
+				acc.PubKey = action.Input.PubKey
+			}
+		}
+		if acc.PubKey == nil {
+			return tmsp.ErrBaseInvalidPubKey.AppendLog("Account has no PubKey")
 		}
 	}
 
@@ -53,9 +83,17 @@ func ExecuteAction(state *State, action Action, isCheckTx bool) (res tmsp.Result
 		return res.PrependLog("in validateInputAdvanced()")
 	}
 
+	// Check permission
+	res = handler.permit(state, acc)
+	if res.IsErr() {
+		return res
+	}
+
 	if isCheckTx {
-		// CheckTx does not set state
-		// Ok, we are done
+		// CheckTx does not run the action but records the
+		// pending sequence so the next tx can follow it
+		acc.Sequence += 1
+		state.SetAccount(action.Input.Address, acc)
 		return tmsp.OK
 	}
 
@@ -63,25 +101,22 @@ func ExecuteAction(state *State, action Action, isCheckTx bool) (res tmsp.Result
 	acc.Sequence += 1
 	accCopy := acc.Copy()
 
-	// Run the action.
+	// Run the action in its own cache so it is
+	// either synced in full or discarded in full
 	cache := state.CacheWrap()
-	switch action.Type {
-	case ActionCreateAccount:
-		res = RunCreateAccount(cache, acc)
-	case ActionRemoveAccount:
-		res = RunRemoveAccount(cache, acc)
-	case ActionSubmitForm:
-		res = RunSubmitForm(cache, acc, action.Data)
-	default:
-		res = tmsp.ErrUnknownRequest.SetLog(
-			Fmt("Error unrecognized tx type: %v", action.Type))
-	}
+	res = handler.Run(cache, acc, v)
 	if res.IsOK() {
-		//log.Info("Success")
-		cache.CacheSync()
-	} else {
-		log.Info("AppTx failed", "error", res)
-		cache.SetAccount(action.Input.Address, accCopy)
+		err := cache.CacheSync()
+		if err != nil {
+			PanicSanity("Error syncing action cache: " + err.Error())
+		}
+		return res
+	}
+	log.Info("AppTx failed", "error", res)
+	// Drop the cache but keep the sequence increment
+	// so the failed action cannot be replayed
+	if !handler.CreatesAccount {
+		state.SetAccount(action.Input.Address, accCopy)
 	}
 	return res
 }
@@ -102,21 +137,28 @@ func RunRemoveAccount(accSetter AccountSetter, acc *Account) tmsp.Result {
 	return tmsp.OK
 }
 
-func RunSubmitForm(state *State, acc *Account, data []byte) (res tmsp.Result) {
+func decodeInfo(data []byte) (interface{}, error) {
 	var info Info
 	err := json.Unmarshal(data, &info)
-	if err != nil {
-		return tmsp.ErrEncodingError.SetLog("Failed to decode data")
+	return info, err
+}
+
+func RunSubmitForm(state *State, acc *Account, info Info) (res tmsp.Result) {
+	res = checkSubmittedAt(state, info.SubmittedAt)
+	if res.IsErr() {
+		return res
 	}
 	cid_json, err := info.ContentID.MarshalJSON()
 	if err != nil {
 		return tmsp.ErrEncodingError.SetLog("Failed to encode content ID")
 	}
-	state.Set(info.FormID, cid_json)
+	state.Set(FormKey(info.FormID), cid_json)
+	state.Set(FormIssueKey(info.FormID), []byte(info.Issue))
+	// Block time and height are recorded in EndBlock
+	state.Set(PendingFormKey(info.FormID), wire.BinaryBytes(info.SubmittedAt))
 	err = state.FilterAdd(info.FormID, info.Issue)
 	if err != nil {
-		// something went wrong...
-		panic(err)
+		return tmsp.ErrBaseInvalidInput.SetLog(err.Error())
 	}
 	acc.AddformID(info)
 	addr := acc.PubKey.Address()
@@ -124,6 +166,55 @@ func RunSubmitForm(state *State, acc *Account, data []byte) (res tmsp.Result) {
 	return tmsp.OK
 }
 
+// Sub-actions run in order against the same state, so if one
+// fails the caller discards the whole batch. Result data holds
+// the per-item results up to and including the first failure.
+
+func validateBatch(v interface{}) tmsp.Result {
+	items := v.([]BatchItem)
+	if len(items) == 0 {
+		return tmsp.ErrBaseInvalidInput.SetLog("Batch must have at least one item")
+	}
+	if len(items) > MaxBatchItems {
+		return tmsp.ErrBaseInvalidInput.SetLog(
+			Fmt("Batch cannot have more than %d items", MaxBatchItems))
+	}
+	return tmsp.OK
+}
+
+func RunBatch(state *State, acc *Account, items []BatchItem) tmsp.Result {
+	results := make([]BatchResult, 0, len(items))
+	for i, item := range items {
+		res := runBatchItem(state, acc, item)
+		results = append(results, BatchResult{res.Code, res.Data, res.Log})
+		if res.IsErr() {
+			return tmsp.NewResult(res.Code, wire.BinaryBytes(results),
+				Fmt("Batch item %d failed: %v", i, res.Log))
+		}
+	}
+	return tmsp.NewResultOK(wire.BinaryBytes(results), "")
+}
+
+func runBatchItem(state *State, acc *Account, item BatchItem) tmsp.Result {
+	handler, res := getHandler(state, item.Type)
+	if res.IsErr() {
+		return res
+	}
+	if !handler.Batchable {
+		return tmsp.ErrBaseInvalidInput.SetLog(
+			Fmt("Action %v is not allowed in a batch", handler.Name))
+	}
+	v, res := handler.decode(item.Data)
+	if res.IsErr() {
+		return res
+	}
+	res = handler.permit(state, acc)
+	if res.IsErr() {
+		return res
+	}
+	return handler.Run(state, acc, v)
+}
+
 //=======================================================================================//
 
 func validateInputAdvanced(acc *Account, signBytes []byte, in *ActionInput) (res tmsp.Result) {
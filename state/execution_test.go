@@ -0,0 +1,603 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+	"gx/ipfs/QmcEcrBAMrwMyhSjXt4yfyPpzgSuV8HLHavnfmiKCSRqZU/go-cid"
+	"testing"
+	"time"
+)
+
+const (
+	testChainID   = "test_chain"
+	testIssue     = "pothole"
+	testContentID = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+)
+
+func newTestState() (*State, *MemStore) {
+	store := NewMemStore()
+	s := NewState(store)
+	s.SetChainID(testChainID)
+	s.SetFilters([]string{testIssue})
+	s.SetGenesisTime(time.Now().Unix())
+	return s, store
+}
+
+func signAction(action Action, privKey crypto.PrivKey, seq int) Action {
+	action.Prepare(privKey.PubKey(), seq)
+	action.Sign(privKey, testChainID)
+	return action
+}
+
+func createTestAccount(t *testing.T, s *State) crypto.PrivKey {
+	privKey := crypto.GenPrivKeyEd25519()
+	action := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte("someone")))
+	res := ExecuteAction(s, signAction(action, privKey, 1), false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	return privKey
+}
+
+func formData(t *testing.T, issue, location string) ([]byte, []byte) {
+	return formDataAt(t, issue, location, time.Now())
+}
+
+func formDataAt(t *testing.T, issue, location string, now time.Time) ([]byte, []byte) {
+	contentID, err := cid.Decode(testContentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := Form{
+		Description: "something happened",
+		Issue:       issue,
+		Location:    location,
+		SubmittedAt: now.UTC().String(),
+	}
+	info := NewInfo(contentID, form, now)
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, info.FormID
+}
+
+func submitFormAction(t *testing.T, issue string, privKey crypto.PrivKey, seq int) (Action, []byte) {
+	data, formID := formData(t, issue, "somewhere")
+	action := NewAction(ActionSubmitForm, data)
+	return signAction(action, privKey, seq), formID
+}
+
+func TestExecuteActionFailureDiscardsWrites(t *testing.T) {
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+	addr := privKey.PubKey().Address()
+
+	// Form key is written before the unknown issue
+	// fails the filter update, midway through the action
+	action, formID := submitFormAction(t, "unknown issue", privKey, 2)
+	res := ExecuteAction(s, action, false)
+	if res.IsOK() {
+		t.Fatal("Expected action with unknown issue to fail")
+	}
+	if store.Get(FormKey(formID)) != nil {
+		t.Error("Expected form write to be discarded")
+	}
+	acc := s.GetAccount(addr)
+	if len(acc.FormIDs) != 0 {
+		t.Errorf("Expected no form IDs, got %v", acc.FormIDs)
+	}
+	if acc.Sequence != 2 {
+		t.Errorf("Expected failed action to consume sequence 2, got %d", acc.Sequence)
+	}
+
+	// Replaying the failed action is rejected
+	res = ExecuteAction(s, action, false)
+	if res.IsOK() {
+		t.Error("Expected replayed action to fail")
+	}
+
+	// Next action succeeds and is synced in full
+	action, formID = submitFormAction(t, testIssue, privKey, 3)
+	res = ExecuteAction(s, action, false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	if store.Get(FormKey(formID)) == nil {
+		t.Error("Expected form to be written")
+	}
+	has, err := s.FilterLookup(formID, testIssue)
+	if err != nil || !has {
+		t.Error("Expected form ID in issue filter")
+	}
+	acc = s.GetAccount(addr)
+	if len(acc.FormIDs) != 1 || acc.Sequence != 3 {
+		t.Errorf("Unexpected account state: %v", acc)
+	}
+}
+
+func TestExecuteBatchAtomic(t *testing.T) {
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+	addr := privKey.PubKey().Address()
+
+	data1, formID1 := formData(t, testIssue, "somewhere")
+	data2, formID2 := formData(t, "unknown issue", "somewhere else")
+	data3, formID3 := formData(t, testIssue, "elsewhere")
+
+	// Second item fails so the first is discarded too
+	items := []BatchItem{{ActionSubmitForm, data1}, {ActionSubmitForm, data2}}
+	res := ExecuteAction(s, signAction(NewBatchAction(items), privKey, 2), false)
+	if res.IsOK() {
+		t.Fatal("Expected batch with failing item to fail")
+	}
+	var results []BatchResult
+	if err := wire.ReadBinaryBytes(res.Data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Code != 0 || results[1].Code == 0 {
+		t.Errorf("Unexpected batch results: %v", results)
+	}
+	if store.Get(FormKey(formID1)) != nil || store.Get(FormKey(formID2)) != nil {
+		t.Error("Expected batch writes to be discarded")
+	}
+
+	// Both items succeed under one sequence number
+	items = []BatchItem{{ActionSubmitForm, data1}, {ActionSubmitForm, data3}}
+	res = ExecuteAction(s, signAction(NewBatchAction(items), privKey, 3), false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	results = nil
+	if err := wire.ReadBinaryBytes(res.Data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 batch results, got %d", len(results))
+	}
+	if store.Get(FormKey(formID1)) == nil || store.Get(FormKey(formID3)) == nil {
+		t.Error("Expected batch writes to be synced")
+	}
+	acc := s.GetAccount(addr)
+	if len(acc.FormIDs) != 2 || acc.Sequence != 3 {
+		t.Errorf("Unexpected account state: %v", acc)
+	}
+}
+
+func TestBatchRemoveAccount(t *testing.T) {
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+	addr := privKey.PubKey().Address()
+
+	data, formID := formData(t, testIssue, "somewhere")
+	items := []BatchItem{{ActionRemoveAccount, nil}, {ActionSubmitForm, data}}
+	res := ExecuteAction(s, signAction(NewBatchAction(items), privKey, 2), false)
+	if res.IsOK() {
+		t.Fatal("Expected batch with remove_account to fail")
+	}
+	if store.Get(FormKey(formID)) != nil {
+		t.Error("Expected batch writes to be discarded")
+	}
+	acc := s.GetAccount(addr)
+	if acc == nil || acc.Sequence != 2 {
+		t.Errorf("Expected account to remain with sequence 2, got %v", acc)
+	}
+
+	// Removed on its own, the account stays removed
+	res = ExecuteAction(s, signAction(NewAction(ActionRemoveAccount, nil), privKey, 3), false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	if s.GetAccount(addr) != nil {
+		t.Error("Expected account to be removed")
+	}
+}
+
+func TestCreateAccountExists(t *testing.T) {
+	s, _ := newTestState()
+	privKey := createTestAccount(t, s)
+	addr := privKey.PubKey().Address()
+
+	acc := s.GetAccount(addr)
+	acc.Roles = []string{RoleAdmin}
+	acc.FormIDs = []string{"A"}
+	s.SetAccount(addr, acc)
+
+	// Sequence 1 again, as for a new account
+	action := NewAction(ActionCreateAccount, wire.BinaryBytes([]byte("someone else")))
+	res := ExecuteAction(s, signAction(action, privKey, 1), false)
+	if res.Code != tmsp.CodeType_BaseInvalidInput {
+		t.Fatalf("Expected invalid input, got %v", res)
+	}
+	acc = s.GetAccount(addr)
+	if acc.Username != "someone" || !acc.HasRole(RoleAdmin) || len(acc.FormIDs) != 1 {
+		t.Errorf("Expected account to be unchanged, got %v", acc)
+	}
+}
+
+func TestForeignPubKeyRejected(t *testing.T) {
+	s, _ := newTestState()
+	adminKey := crypto.GenPrivKeyEd25519()
+	addr := adminKey.PubKey().Address()
+	admin := NewAccount(adminKey.PubKey(), "admin")
+	admin.Roles = []string{RoleAdmin}
+	s.SetAccount(addr, admin)
+
+	// Signed with another key, claiming the admin's address
+	// at sequence 1 as for a genesis account
+	foreignKey := crypto.GenPrivKeyEd25519()
+	data := wire.BinaryBytes(Upgrade{Height: 10, Version: 1})
+	action := NewAction(ActionScheduleUpgrade, data)
+	action.Prepare(foreignKey.PubKey(), 1)
+	action.Input.Address = addr
+	action.Sign(foreignKey, testChainID)
+
+	res := ExecuteAction(s, action, false)
+	if res.Code != tmsp.CodeType_BaseInvalidPubKey {
+		t.Fatalf("Expected invalid pub key, got %v", res)
+	}
+	acc := s.GetAccount(addr)
+	if !acc.PubKey.Equals(adminKey.PubKey()) || acc.Sequence != 0 {
+		t.Errorf("Expected admin account to be unchanged, got %v", acc)
+	}
+}
+
+func TestCacheWrapDiscard(t *testing.T) {
+	s, store := newTestState()
+	formID := []byte("0123456789abcdef")
+
+	cache := s.CacheWrap()
+	cache.Set(FormKey(formID), []byte("value"))
+	if err := cache.FilterAdd(formID, testIssue); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := cache.FilterLookup(formID, testIssue); !has {
+		t.Error("Expected staged filter add to be visible in cache")
+	}
+
+	// Dropped without syncing
+	if store.Get(FormKey(formID)) != nil {
+		t.Error("Expected store to be untouched")
+	}
+	if has, _ := s.FilterLookup(formID, testIssue); has {
+		t.Error("Expected filter to be untouched")
+	}
+}
+
+func TestCacheSyncFilterFailure(t *testing.T) {
+	s, store := newTestState()
+	formID := []byte("0123456789abcdef")
+	missing := []byte("fedcba9876543210")
+
+	cache := s.CacheWrap()
+	cache.Set(FormKey(formID), []byte("value"))
+	if err := cache.FilterAdd(formID, testIssue); err != nil {
+		t.Fatal(err)
+	}
+	// Never added, so the delete fails on sync
+	if err := cache.FilterDelete(missing, testIssue); err != nil {
+		t.Fatal(err)
+	}
+	if cache.CacheSync() == nil {
+		t.Fatal("Expected sync to fail")
+	}
+	if store.Get(FormKey(formID)) != nil {
+		t.Error("Expected store to be untouched")
+	}
+	if has, _ := s.FilterLookup(formID, testIssue); has {
+		t.Error("Expected filter add to be undone")
+	}
+}
+
+func TestAccountCopy(t *testing.T) {
+	acc := NewAccount(crypto.GenPrivKeyEd25519().PubKey(), "someone")
+	acc.FormIDs = []string{"A"}
+	accCopy := acc.Copy()
+	accCopy.Sequence++
+	accCopy.FormIDs[0] = "B"
+	if acc.Sequence != 0 || acc.FormIDs[0] != "A" {
+		t.Error("Expected copy to be independent of original")
+	}
+}
+
+func TestGetAccountBeforeRoles(t *testing.T) {
+	s, store := newTestState()
+	pubKey := crypto.GenPrivKeyEd25519().PubKey()
+	addr := pubKey.Address()
+
+	// Layout of accounts stored before roles
+	old := &struct {
+		FormIDs  []string
+		PubKey   crypto.PubKey
+		Sequence int
+		Username string
+	}{[]string{"A"}, pubKey, 4, "someone"}
+	store.Set(AccountKey(addr), wire.BinaryBytes(old))
+
+	acc := s.GetAccount(addr)
+	if acc == nil || acc.Sequence != 4 || acc.Username != "someone" ||
+		len(acc.FormIDs) != 1 || len(acc.Roles) != 0 {
+		t.Fatalf("Unexpected account: %v", acc)
+	}
+
+	// Written back in the current layout
+	acc.Roles = []string{RoleAdmin}
+	s.SetAccount(addr, acc)
+	acc = s.GetAccount(addr)
+	if acc.Sequence != 4 || acc.Username != "someone" || !acc.HasRole(RoleAdmin) {
+		t.Fatalf("Unexpected account: %v", acc)
+	}
+}
+
+func TestEndBlockRecordsFormMeta(t *testing.T) {
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+
+	s.SetBlockHeight(7)
+	action, formID := submitFormAction(t, testIssue, privKey, 2)
+	res := ExecuteAction(s, action, false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	EndBlock(s)
+
+	meta := s.GetFormMeta(formID)
+	if meta == nil || meta.Height != 7 || meta.Time != s.GetBlockTime() {
+		t.Fatalf("Unexpected form meta: %v", meta)
+	}
+	if store.Get(PendingFormKey(formID)) != nil {
+		t.Error("Expected pending form to be cleared")
+	}
+	if store.Get(FormTimeKey(meta.Time, formID)) == nil {
+		t.Error("Expected form in time index")
+	}
+}
+
+func TestBlockTimeBounded(t *testing.T) {
+	s, _ := newTestState()
+	privKey := createTestAccount(t, s)
+	genesis := time.Now()
+	s.SetGenesisTime(genesis.Unix())
+
+	submit := func(seq int, at time.Time) tmsp.Result {
+		data, _ := formDataAt(t, testIssue, fmt.Sprintf("location %d", seq), at)
+		action := signAction(NewAction(ActionSubmitForm, data), privKey, seq)
+		return ExecuteAction(s, action, false)
+	}
+	bound := time.Duration(MaxBlockAdvance+MaxClockDrift) * time.Second
+
+	// Far future timestamps are rejected in the first block
+	s.SetBlockHeight(1)
+	if res := submit(2, genesis.AddDate(10, 0, 0)); res.IsOK() {
+		t.Fatal("Expected far future form to be rejected")
+	}
+
+	// A block of timestamps at the bound moves block
+	// time at most MaxBlockAdvance past genesis
+	for seq := 3; seq < 6; seq++ {
+		if res := submit(seq, genesis.Add(bound)); res.IsErr() {
+			t.Fatal(res.Error())
+		}
+	}
+	EndBlock(s)
+	if s.GetBlockTime() != genesis.Unix()+MaxBlockAdvance {
+		t.Errorf("Expected block time %d, got %d", genesis.Unix()+MaxBlockAdvance, s.GetBlockTime())
+	}
+
+	// Honest forms are still accepted
+	s.SetBlockHeight(2)
+	if res := submit(6, genesis.Add(time.Minute)); res.IsErr() {
+		t.Fatal(res.Error())
+	}
+
+	// The bound grows with the blocks since block time was set
+	s.SetBlockHeight(5)
+	if s.MaxBlockTime() != genesis.Unix()+4*MaxBlockAdvance {
+		t.Errorf("Expected max block time %d, got %d", genesis.Unix()+4*MaxBlockAdvance, s.MaxBlockTime())
+	}
+}
+
+func TestBlockTimeRequiresGenesisTime(t *testing.T) {
+	s := NewState(NewMemStore())
+	s.SetChainID(testChainID)
+	s.SetFilters([]string{testIssue})
+	privKey := createTestAccount(t, s)
+
+	s.SetBlockHeight(1)
+	action, _ := submitFormAction(t, testIssue, privKey, 2)
+	if res := ExecuteAction(s, action, false); res.IsOK() {
+		t.Error("Expected form to be rejected without genesis time")
+	}
+}
+
+func TestExecuteActionExpired(t *testing.T) {
+	s, _ := newTestState()
+	privKey := createTestAccount(t, s)
+
+	s.SetBlockHeight(11)
+	action, _ := submitFormAction(t, testIssue, privKey, 2)
+	action.SetValidUntil(10)
+	action = signAction(action, privKey, 2)
+	res := ExecuteAction(s, action, false)
+	if res.IsOK() {
+		t.Fatal("Expected expired action to fail")
+	}
+
+	action.SetValidUntil(11)
+	action = signAction(action, privKey, 2)
+	res = ExecuteAction(s, action, false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+}
+
+func TestRegisteredAction(t *testing.T) {
+	const actionNote = 0xF0
+	noteKey := []byte("test/note")
+	RegisterAction(&ActionHandler{
+		Type: actionNote,
+		Name: "test_note",
+		Decode: func(data []byte) (interface{}, error) {
+			return string(data), nil
+		},
+		Permit: func(state *State, acc *Account) bool {
+			return acc.Username == "someone"
+		},
+		Run: func(state *State, acc *Account, v interface{}) tmsp.Result {
+			state.Set(noteKey, []byte(v.(string)))
+			return tmsp.OK
+		},
+		Batchable: true,
+	})
+	defer func() {
+		delete(handlers, actionNote)
+		delete(handlerNames, "test_note")
+	}()
+
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+
+	action := NewAction(actionNote, []byte("hello"))
+	res := ExecuteAction(s, signAction(action, privKey, 2), false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	if string(store.Get(noteKey)) != "hello" {
+		t.Error("Expected registered action to run")
+	}
+
+	items := []BatchItem{{actionNote, []byte("batched")}}
+	res = ExecuteAction(s, signAction(NewBatchAction(items), privKey, 3), false)
+	if res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	if string(store.Get(noteKey)) != "batched" {
+		t.Error("Expected registered action to run in batch")
+	}
+}
+
+func TestUpgradeSwitchesHandler(t *testing.T) {
+	const actionEcho = 0xF1
+	echoKey := []byte("test/echo")
+	for version, value := range map[int]string{1: "v1", 2: "v2"} {
+		value := value
+		RegisterAction(&ActionHandler{
+			Type:   actionEcho,
+			Name:   "test_echo",
+			Decode: decodeNothing,
+			Run: func(state *State, acc *Account, v interface{}) tmsp.Result {
+				state.Set(echoKey, []byte(value))
+				return tmsp.OK
+			},
+			MinVersion: version,
+			MaxVersion: version,
+		})
+	}
+	defer func() {
+		delete(handlers, actionEcho)
+		delete(handlerNames, "test_echo")
+	}()
+
+	defer func(max int) { MaxSupportedVersion = max }(MaxSupportedVersion)
+	MaxSupportedVersion = 3
+
+	s, store := newTestState()
+	privKey := createTestAccount(t, s)
+	if err := s.ScheduleUpgrade(Upgrade{Height: 5, Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ScheduleUpgrade(Upgrade{Height: 4, Version: 3}); err == nil {
+		t.Error("Expected upgrade before the last upgrade to be rejected")
+	}
+
+	for seq, height := range []int{4, 5} {
+		s.SetBlockHeight(height)
+		action := NewAction(actionEcho, nil)
+		res := ExecuteAction(s, signAction(action, privKey, seq+2), false)
+		if res.IsErr() {
+			t.Fatal(res.Error())
+		}
+		want := map[int]string{4: "v1", 5: "v2"}[height]
+		if got := string(store.Get(echoKey)); got != want {
+			t.Errorf("Height %v: got %v, want %v", height, got, want)
+		}
+	}
+
+	// Only admins can schedule upgrades
+	data := wire.BinaryBytes(Upgrade{Height: 10, Version: 3})
+	action := NewAction(ActionScheduleUpgrade, data)
+	res := ExecuteAction(s, signAction(action, privKey, 4), false)
+	if res.IsOK() {
+		t.Error("Expected non-admin upgrade to be rejected")
+	}
+}
+
+func TestScheduleUpgradeChecks(t *testing.T) {
+	defer func(max int) { MaxSupportedVersion = max }(MaxSupportedVersion)
+	MaxSupportedVersion = 2
+
+	s, _ := newTestState()
+	s.SetBlockHeight(100)
+
+	if err := s.ScheduleUpgrade(Upgrade{Height: 100 + MinUpgradeLead - 1, Version: 2}); err == nil {
+		t.Error("Expected upgrade without enough lead time to be rejected")
+	}
+	if err := s.ScheduleUpgrade(Upgrade{Height: 100 + MinUpgradeLead, Version: 3}); err == nil {
+		t.Error("Expected unsupported version to be rejected")
+	}
+	if err := s.ScheduleUpgrade(Upgrade{Height: 100 + MinUpgradeLead, Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if upgrades := s.GetUpgrades(); len(upgrades) != 1 {
+		t.Errorf("Expected 1 upgrade, got %v", upgrades)
+	}
+}
+
+func TestSetValidator(t *testing.T) {
+	s, _ := newTestState()
+	privKey := createTestAccount(t, s)
+	addr := privKey.PubKey().Address()
+	acc := s.GetAccount(addr)
+	acc.Roles = []string{RoleAdmin}
+	s.SetAccount(addr, acc)
+
+	city := crypto.GenPrivKeyEd25519().PubKey()
+	library := crypto.GenPrivKeyEd25519().PubKey()
+	err := s.InitValidators([]*tmsp.Validator{{PubKey: city.Bytes(), Power: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setValidator := func(pubKey crypto.PubKey, power uint64, seq int) tmsp.Result {
+		data := wire.BinaryBytes(&Validator{pubKey, power})
+		action := NewAction(ActionSetValidator, data)
+		return ExecuteAction(s, signAction(action, privKey, seq), false)
+	}
+
+	// Add the library, remove the city
+	if res := setValidator(library, 5, 2); res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	if res := setValidator(city, 0, 3); res.IsErr() {
+		t.Fatal(res.Error())
+	}
+	diffs := EndBlock(s)
+	if len(diffs) != 2 {
+		t.Fatalf("Expected 2 validator diffs, got %v", diffs)
+	}
+	if len(EndBlock(s)) != 0 {
+		t.Error("Expected diffs to be cleared")
+	}
+	validators := s.GetValidators()
+	if len(validators) != 1 || !validators[0].PubKey.Equals(library) {
+		t.Errorf("Unexpected validators: %v", validators)
+	}
+
+	if res := setValidator(library, 0, 4); res.IsOK() {
+		t.Error("Expected removing the last validator to fail")
+	}
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	. "github.com/tendermint/go-common"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+)
+
+// ActionHandler describes an action type. Core actions register
+// in actions.go; other packages, e.g. a petitions module, can call
+// RegisterAction from their own init without touching ExecuteAction.
+
+type ActionHandler struct {
+	Type byte
+	Name string
+
+	// Decode parses action data; Validate checks the
+	// decoded value without looking at state
+	Decode   func(data []byte) (interface{}, error)
+	Validate func(v interface{}) tmsp.Result
+
+	// Permit reports whether the input account may run the action.
+	// A nil Permit lets any account run it.
+	Permit func(state *State, acc *Account) bool
+
+	// Run executes the action in its own cache
+	Run func(state *State, acc *Account, v interface{}) tmsp.Result
+
+	// The input account is created by the action (sequence 1)
+	CreatesAccount bool
+
+	// The action can be a sub-action of a batch
+	Batchable bool
+
+	// Rule versions the handler applies to; zero MaxVersion
+	// means it stays active. A type can have one handler per
+	// version, so behavior can change at an upgrade height.
+	MinVersion int
+	MaxVersion int
+}
+
+func (handler *ActionHandler) activeAt(version int) bool {
+	if version < handler.MinVersion {
+		return false
+	}
+	return handler.MaxVersion == 0 || version <= handler.MaxVersion
+}
+
+func (handler *ActionHandler) overlaps(other *ActionHandler) bool {
+	if handler.MaxVersion != 0 && handler.MaxVersion < other.MinVersion {
+		return false
+	}
+	if other.MaxVersion != 0 && other.MaxVersion < handler.MinVersion {
+		return false
+	}
+	return true
+}
+
+var (
+	handlers     = make(map[byte][]*ActionHandler)
+	handlerNames = make(map[string]byte)
+)
+
+func RegisterAction(handler *ActionHandler) {
+	if handler.Run == nil || handler.Decode == nil {
+		PanicSanity(Fmt("Action %v must have Decode and Run", handler.Name))
+	}
+	if handler.MinVersion == 0 {
+		handler.MinVersion = InitialVersion
+	}
+	for _, other := range handlers[handler.Type] {
+		if handler.overlaps(other) {
+			PanicSanity(Fmt("Action type %X is already registered for version %v",
+				handler.Type, handler.MinVersion))
+		}
+	}
+	if actionType, ok := handlerNames[handler.Name]; ok && actionType != handler.Type {
+		PanicSanity(Fmt("Action name %v is already registered", handler.Name))
+	}
+	handlers[handler.Type] = append(handlers[handler.Type], handler)
+	handlerNames[handler.Name] = handler.Type
+}
+
+func GetActionHandler(actionType byte, version int) *ActionHandler {
+	for _, handler := range handlers[actionType] {
+		if handler.activeAt(version) {
+			return handler
+		}
+	}
+	return nil
+}
+
+func GetActionHandlerByName(name string, version int) *ActionHandler {
+	actionType, ok := handlerNames[name]
+	if !ok {
+		return nil
+	}
+	return GetActionHandler(actionType, version)
+}
+
+func getHandler(state *State, actionType byte) (*ActionHandler, tmsp.Result) {
+	version := state.GetVersion()
+	handler := GetActionHandler(actionType, version)
+	if handler == nil {
+		return nil, tmsp.ErrUnknownRequest.SetLog(
+			Fmt("Error unrecognized tx type: %v (version=%v)", actionType, version))
+	}
+	return handler, tmsp.OK
+}
+
+func (handler *ActionHandler) decode(data []byte) (interface{}, tmsp.Result) {
+	v, err := handler.Decode(data)
+	if err != nil {
+		return nil, tmsp.ErrEncodingError.SetLog(
+			Fmt("Failed to decode %v data: %v", handler.Name, err.Error()))
+	}
+	if handler.Validate != nil {
+		res := handler.Validate(v)
+		if res.IsErr() {
+			return nil, res
+		}
+	}
+	return v, tmsp.OK
+}
+
+func (handler *ActionHandler) permit(state *State, acc *Account) tmsp.Result {
+	if handler.Permit != nil && !handler.Permit(state, acc) {
+		return tmsp.ErrUnauthorized.SetLog(
+			Fmt("Account is not permitted to run %v", handler.Name))
+	}
+	return tmsp.OK
+}
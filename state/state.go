@@ -1,6 +1,7 @@
 package state
 
 import (
+	"bytes"
 	"github.com/pkg/errors"
 	. "github.com/tendermint/go-common"
 	"github.com/tendermint/go-wire"
@@ -10,9 +11,21 @@ import (
 
 type State struct {
 	chainID string
+	height  int
 	filters map[string]dl_cbf.HashTable
 	store   types.Store
 	*types.Cache
+
+	// Set on cache-wrapped states; filter updates
+	// are staged until the cache is synced
+	parent  *State
+	pending []filterOp
+}
+
+type filterOp struct {
+	data   []byte
+	name   string
+	delete bool
 }
 
 func NewState(store types.Store) *State {
@@ -50,6 +63,10 @@ func (s *State) Set(key []byte, value []byte) {
 	s.store.Set(key, value)
 }
 
+func (s *State) Iterate(start, end []byte, fun func(key, value []byte) bool) (bool, error) {
+	return s.store.Iterate(start, end, fun)
+}
+
 func (s *State) GetAccount(addr []byte) *types.Account {
 	return GetAccount(s.store, addr)
 }
@@ -63,6 +80,10 @@ func (s *State) FilterAdd(data []byte, name string) error { //must add
 	if !ok {
 		return errors.New(Fmt("Failed to find '%s' filter", name))
 	}
+	if s.parent != nil {
+		s.pending = append(s.pending, filterOp{data, name, false})
+		return nil
+	}
 	_, success := filter.Add(data)
 	if !success {
 		return errors.New(Fmt("Failed to add data to '%s' filter", name))
@@ -75,6 +96,16 @@ func (s *State) FilterLookup(data []byte, name string) (bool, error) {
 	if !ok {
 		return false, errors.New(Fmt("Failed to find '%s' filter", name))
 	}
+	// Latest staged update wins over the parent
+	for i := len(s.pending) - 1; i >= 0; i-- {
+		op := s.pending[i]
+		if op.name == name && bytes.Equal(op.data, data) {
+			return !op.delete, nil
+		}
+	}
+	if s.parent != nil {
+		return s.parent.FilterLookup(data, name)
+	}
 	_, found := filter.Lookup(data)
 	return found, nil
 }
@@ -93,6 +124,10 @@ func (s *State) FilterDelete(data []byte, name string) error { //must delete
 	if !ok {
 		return errors.New(Fmt("Failed to find '%s' filter", name))
 	}
+	if s.parent != nil {
+		s.pending = append(s.pending, filterOp{data, name, true})
+		return nil
+	}
 	_, success := filter.Delete(data)
 	if !success {
 		return errors.New(Fmt("Failed to delete data from '%s' filter", name))
@@ -126,19 +161,70 @@ func (s *State) CacheWrap() *State {
 	cache := types.NewCache(s.store)
 	snew := &State{
 		chainID: s.chainID,
+		height:  s.height,
 		filters: s.filters,
 		store:   cache,
+		parent:  s,
 	}
 	snew.Cache = cache
 	return snew
 }
 
-func (s *State) CacheSync() {
+// CacheSync writes the staged filter updates and then the
+// cached state to the parent. If a filter update fails, the
+// ones already made are undone and the cached state is not
+// written, so the parent is left as it was. Dropping a
+// cache-wrapped state without syncing discards all of its changes.
+
+func (s *State) CacheSync() error {
+	parent := s.parent
+	staged := len(parent.pending)
+	for i, op := range s.pending {
+		err := parent.applyFilterOp(op)
+		if err == nil {
+			continue
+		}
+		if parent.parent != nil {
+			parent.pending = parent.pending[:staged]
+			return err
+		}
+		for j := i - 1; j >= 0; j-- {
+			undo := s.pending[j]
+			undo.delete = !undo.delete
+			parent.applyFilterOp(undo)
+		}
+		return err
+	}
+	s.pending = nil
 	s.Sync()
+	return nil
 }
 
+func (s *State) applyFilterOp(op filterOp) error {
+	if op.delete {
+		return s.FilterDelete(op.data, op.name)
+	}
+	return s.FilterAdd(op.data, op.name)
+}
+
+const (
+	AccountPrefix = "base/a/"
+	FormPrefix    = "base/f/"
+
+	// Issue of each form, so filters can be rebuilt exactly
+	FormIssuePrefix = "base/i/"
+)
+
 func AccountKey(addr []byte) []byte {
-	return append([]byte("base/a/"), addr...)
+	return append([]byte(AccountPrefix), addr...)
+}
+
+func FormKey(formID []byte) []byte {
+	return append([]byte(FormPrefix), formID...)
+}
+
+func FormIssueKey(formID []byte) []byte {
+	return append([]byte(FormIssuePrefix), formID...)
 }
 
 func GetAccount(store types.Store, addr []byte) *types.Account {
@@ -146,8 +232,7 @@ func GetAccount(store types.Store, addr []byte) *types.Account {
 	if len(data) == 0 {
 		return nil
 	}
-	var acc *types.Account
-	err := wire.ReadBinaryBytes(data, &acc)
+	acc, err := types.DecodeAccount(data)
 	if err != nil {
 		panic(Fmt("Error reading account %X error: %v",
 			data, err.Error()))
@@ -156,6 +241,10 @@ func GetAccount(store types.Store, addr []byte) *types.Account {
 }
 
 func SetAccount(store types.Store, addr []byte, acc *types.Account) {
+	if acc == nil {
+		store.Set(AccountKey(addr), nil)
+		return
+	}
 	accBytes := wire.BinaryBytes(acc)
 	store.Set(AccountKey(addr), accBytes)
 }
@@ -0,0 +1,163 @@
+package state
+
+import (
+	"github.com/pkg/errors"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+)
+
+// Execution rules are versioned. The schedule of upgrades is
+// kept in state, so every validator switches to a new version
+// at the same height. Handlers for a new version can ship in a
+// release ahead of time and stay inactive until that height.
+
+const InitialVersion = 1
+
+// Latest version this binary can execute. Releases that
+// ship handlers for a later version raise it.
+var MaxSupportedVersion = 1
+
+// Blocks between scheduling an upgrade and its height,
+// so operators have time to install a supporting release
+const MinUpgradeLead = 10000
+
+const UpgradesKey = "base/upgrades"
+
+func (s *State) GetUpgrades() []Upgrade {
+	data := s.Get([]byte(UpgradesKey))
+	if len(data) == 0 {
+		return nil
+	}
+	var upgrades []Upgrade
+	err := wire.ReadBinaryBytes(data, &upgrades)
+	if err != nil {
+		PanicSanity("Error reading upgrades: " + err.Error())
+	}
+	return upgrades
+}
+
+// Version of the rules at the current block height
+func (s *State) GetVersion() int {
+	return VersionAt(s.GetUpgrades(), s.GetBlockHeight())
+}
+
+func VersionAt(upgrades []Upgrade, height int) int {
+	version := InitialVersion
+	for _, upgrade := range upgrades {
+		if upgrade.Height > height {
+			break
+		}
+		version = upgrade.Version
+	}
+	return version
+}
+
+// Upgrades must come at least MinUpgradeLead blocks after the
+// current height, after every upgrade already scheduled, and be
+// to a version this binary supports. Upgrades in genesis are
+// known before the chain starts, so they need no lead time.
+
+func (s *State) ScheduleUpgrade(upgrade Upgrade) error {
+	height := s.GetBlockHeight()
+	minHeight := height + MinUpgradeLead
+	if height == 0 {
+		minHeight = 1
+	}
+	if upgrade.Height < minHeight {
+		return errors.Errorf("Upgrade height %v must be at least %v, %v blocks after current height %v",
+			upgrade.Height, minHeight, minHeight-height, height)
+	}
+	if upgrade.Version > MaxSupportedVersion {
+		return errors.Errorf("Upgrade version %v is newer than supported version %v",
+			upgrade.Version, MaxSupportedVersion)
+	}
+	upgrades := s.GetUpgrades()
+	last := Upgrade{0, InitialVersion}
+	if len(upgrades) > 0 {
+		last = upgrades[len(upgrades)-1]
+	}
+	if upgrade.Height <= last.Height {
+		return errors.Errorf("Upgrade height %v must be after last upgrade height %v",
+			upgrade.Height, last.Height)
+	}
+	if upgrade.Version <= last.Version {
+		return errors.Errorf("Upgrade version %v must be greater than %v",
+			upgrade.Version, last.Version)
+	}
+	upgrades = append(upgrades, upgrade)
+	s.Set([]byte(UpgradesKey), wire.BinaryBytes(upgrades))
+	return nil
+}
+
+// The version active at height applies from height 1,
+// and later upgrades keep their distance from height
+
+func (s *State) rebaseUpgrades(height int) {
+	upgrades := s.GetUpgrades()
+	if len(upgrades) == 0 {
+		return
+	}
+	var rebased []Upgrade
+	if version := VersionAt(upgrades, height); version > InitialVersion {
+		rebased = append(rebased, Upgrade{1, version})
+	}
+	for _, upgrade := range upgrades {
+		if upgrade.Height <= height {
+			continue
+		}
+		upgrade.Height -= height
+		if len(rebased) > 0 && rebased[0].Height == upgrade.Height {
+			// Takes effect at height 1 anyway
+			rebased = rebased[:0]
+		}
+		rebased = append(rebased, upgrade)
+	}
+	if len(rebased) == 0 {
+		s.Set([]byte(UpgradesKey), nil)
+		return
+	}
+	s.Set([]byte(UpgradesKey), wire.BinaryBytes(rebased))
+}
+
+// A node that cannot execute the active rules must stop
+// rather than compute a different state from its peers
+func (s *State) CheckVersion() error {
+	version := s.GetVersion()
+	if version > MaxSupportedVersion {
+		return errors.Errorf("Rules version %v is active at height %v but this binary supports up to %v; please upgrade",
+			version, s.GetBlockHeight(), MaxSupportedVersion)
+	}
+	return nil
+}
+
+//=====================================================================//
+
+func IsAdmin(state *State, acc *Account) bool {
+	return acc.HasRole(RoleAdmin)
+}
+
+func init() {
+	RegisterAction(&ActionHandler{
+		Type:   ActionScheduleUpgrade,
+		Name:   "schedule_upgrade",
+		Decode: decodeUpgrade,
+		Permit: IsAdmin,
+		Run:    runScheduleUpgrade,
+	})
+}
+
+func decodeUpgrade(data []byte) (interface{}, error) {
+	var upgrade Upgrade
+	err := wire.ReadBinaryBytes(data, &upgrade)
+	return upgrade, err
+}
+
+func runScheduleUpgrade(state *State, acc *Account, v interface{}) tmsp.Result {
+	err := state.ScheduleUpgrade(v.(Upgrade))
+	if err != nil {
+		return tmsp.ErrBaseInvalidInput.SetLog(err.Error())
+	}
+	return tmsp.OK
+}
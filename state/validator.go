@@ -0,0 +1,151 @@
+package state
+
+import (
+	"github.com/pkg/errors"
+	. "github.com/tendermint/go-common"
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	tmsp "github.com/tendermint/tmsp/types"
+	. "github.com/zballs/comit/types"
+)
+
+// The validator set is kept in state, so admins can add or remove
+// validators, e.g. the city's partner organizations, with actions.
+// Changes in a block are staged and returned to Tendermint as
+// diffs from EndBlock.
+
+const (
+	ValidatorPrefix        = "base/v/"
+	PendingValidatorPrefix = "base/u/"
+)
+
+func ValidatorKey(addr []byte) []byte {
+	return append([]byte(ValidatorPrefix), addr...)
+}
+
+func PendingValidatorKey(addr []byte) []byte {
+	return append([]byte(PendingValidatorPrefix), addr...)
+}
+
+func (s *State) GetValidator(addr []byte) *Validator {
+	return readValidator(s.Get(ValidatorKey(addr)))
+}
+
+func (s *State) GetValidators() (validators []*Validator) {
+	_, err := IteratePrefix(s, []byte(ValidatorPrefix), func(key, value []byte) bool {
+		validators = append(validators, readValidator(value))
+		return false
+	})
+	if err != nil {
+		PanicSanity("Error reading validators: " + err.Error())
+	}
+	return validators
+}
+
+func readValidator(data []byte) *Validator {
+	if len(data) == 0 {
+		return nil
+	}
+	var v *Validator
+	err := wire.ReadBinaryBytes(data, &v)
+	if err != nil {
+		PanicSanity("Error reading validator: " + err.Error())
+	}
+	return v
+}
+
+// SetValidator updates the set and stages the diff
+// for EndBlock. The set can never become empty.
+
+func (s *State) SetValidator(v *Validator) error {
+	if v.PubKey == nil {
+		return errors.New("Validator must have a public key")
+	}
+	addr := v.PubKey.Address()
+	if v.Power == 0 {
+		if s.GetValidator(addr) == nil {
+			return errors.Errorf("%X is not a validator", addr)
+		}
+		if len(s.GetValidators()) == 1 {
+			return errors.New("Cannot remove the last validator")
+		}
+		s.Set(ValidatorKey(addr), nil)
+	} else {
+		s.Set(ValidatorKey(addr), wire.BinaryBytes(v))
+	}
+	s.Set(PendingValidatorKey(addr), wire.BinaryBytes(v))
+	return nil
+}
+
+// InitValidators replaces the set with the validators
+// Tendermint starts the chain with. No diffs are staged.
+
+func (s *State) InitValidators(validators []*tmsp.Validator) error {
+	for _, v := range s.GetValidators() {
+		s.Set(ValidatorKey(v.PubKey.Address()), nil)
+	}
+	for _, tv := range validators {
+		pubKey, err := crypto.PubKeyFromBytes(tv.PubKey)
+		if err != nil {
+			return errors.Wrap(err, "decoding validator public key")
+		}
+		v := &Validator{pubKey, tv.Power}
+		s.Set(ValidatorKey(pubKey.Address()), wire.BinaryBytes(v))
+	}
+	return nil
+}
+
+// Diffs staged in the block, cleared once read
+func validatorDiffs(state *State) (diffs []*tmsp.Validator) {
+	var keys [][]byte
+	_, err := IteratePrefix(state, []byte(PendingValidatorPrefix), func(key, value []byte) bool {
+		v := readValidator(value)
+		keys = append(keys, key)
+		diffs = append(diffs, &tmsp.Validator{
+			PubKey: v.PubKey.Bytes(),
+			Power:  v.Power,
+		})
+		return false
+	})
+	if err != nil {
+		PanicSanity("Error reading validator diffs: " + err.Error())
+	}
+	for _, key := range keys {
+		state.Set(key, nil)
+	}
+	return diffs
+}
+
+//=====================================================================//
+
+func init() {
+	RegisterAction(&ActionHandler{
+		Type:     ActionSetValidator,
+		Name:     "set_validator",
+		Decode:   decodeValidator,
+		Validate: validateValidator,
+		Permit:   IsAdmin,
+		Run:      runSetValidator,
+	})
+}
+
+func decodeValidator(data []byte) (interface{}, error) {
+	var v *Validator
+	err := wire.ReadBinaryBytes(data, &v)
+	return v, err
+}
+
+func validateValidator(v interface{}) tmsp.Result {
+	if validator := v.(*Validator); validator == nil || validator.PubKey == nil {
+		return tmsp.ErrBaseInvalidInput.SetLog("Validator must have a public key")
+	}
+	return tmsp.OK
+}
+
+func runSetValidator(state *State, acc *Account, v interface{}) tmsp.Result {
+	err := state.SetValidator(v.(*Validator))
+	if err != nil {
+		return tmsp.ErrBaseInvalidInput.SetLog(err.Error())
+	}
+	return tmsp.OK
+}
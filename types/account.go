@@ -1,13 +1,22 @@
 package types
 
-import "github.com/tendermint/go-crypto"
-import . "github.com/zballs/comit/util"
+import (
+	"github.com/tendermint/go-crypto"
+	"github.com/tendermint/go-wire"
+	. "github.com/zballs/comit/util"
+)
+
+const RoleAdmin = "admin"
+
+// Binary encoding follows field order, so new
+// fields go at the end; see DecodeAccount
 
 type Account struct {
 	FormIDs  []string      `json:"form_ids"`
 	PubKey   crypto.PubKey `json:"pub_key"`
 	Sequence int           `json:"sequence"`
 	Username string        `json:"username"`
+	Roles    []string      `json:"roles"`
 }
 
 func NewAccount(pubKey crypto.PubKey, username string) *Account {
@@ -18,13 +27,60 @@ func NewAccount(pubKey crypto.PubKey, username string) *Account {
 	}
 }
 
+// Accounts stored before roles have no Roles field. They fail
+// to decode as Account, since the encoding ends early, and are
+// read in the old layout; they are written back in the new one.
+
+type accountNoRoles struct {
+	FormIDs  []string
+	PubKey   crypto.PubKey
+	Sequence int
+	Username string
+}
+
+func DecodeAccount(data []byte) (*Account, error) {
+	var acc *Account
+	err := wire.ReadBinaryBytes(data, &acc)
+	if err == nil {
+		return acc, nil
+	}
+	var old *accountNoRoles
+	if wire.ReadBinaryBytes(data, &old) != nil || old == nil {
+		return nil, err
+	}
+	return &Account{
+		FormIDs:  old.FormIDs,
+		PubKey:   old.PubKey,
+		Sequence: old.Sequence,
+		Username: old.Username,
+	}, nil
+}
+
 func (acc *Account) AddformID(info Info) {
 	formID := BytesToHexstr(info.FormID)
 	acc.FormIDs = append(acc.FormIDs, formID)
 }
 
+func (acc *Account) HasRole(role string) bool {
+	for _, r := range acc.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
 func (acc *Account) Copy() *Account {
-	return &*acc
+	accCopy := *acc
+	if acc.FormIDs != nil {
+		accCopy.FormIDs = make([]string, len(acc.FormIDs))
+		copy(accCopy.FormIDs, acc.FormIDs)
+	}
+	if acc.Roles != nil {
+		accCopy.Roles = make([]string, len(acc.Roles))
+		copy(accCopy.Roles, acc.Roles)
+	}
+	return &accCopy
 }
 
 type PrivAccount struct {
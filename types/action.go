@@ -12,13 +12,24 @@ const (
 	ActionCreateAccount = 0x01
 	ActionRemoveAccount = 0x02
 	ActionSubmitForm    = 0x03
+	ActionBatch         = 0x04
+
+	// Admin
+	ActionScheduleUpgrade = 0x10
+	ActionSetValidator    = 0x11
 )
 
+const MaxBatchItems = 64
+
+// ValidUntil is the last block height the action can be
+// committed at; zero means the action does not expire
+
 type ActionInput struct {
-	Address   []byte           `json: "address"`
-	Sequence  int              `json: "sequence"`
-	Signature crypto.Signature `json: "signature"`
-	PubKey    crypto.PubKey    `json: "public-key"`
+	Address    []byte           `json: "address"`
+	Sequence   int              `json: "sequence"`
+	Signature  crypto.Signature `json: "signature"`
+	PubKey     crypto.PubKey    `json: "public-key"`
+	ValidUntil int              `json: "valid-until"`
 }
 
 func (in ActionInput) ValidateBasic() tmsp.Result {
@@ -34,18 +45,27 @@ func (in ActionInput) ValidateBasic() tmsp.Result {
 	if in.Sequence > 1 && in.PubKey != nil {
 		return tmsp.ErrBaseInvalidInput.AppendLog("PubKey must be nil when Sequence > 1")
 	}
+	if in.ValidUntil < 0 {
+		return tmsp.ErrBaseInvalidInput.AppendLog("ValidUntil cannot be negative")
+	}
 	return tmsp.OK
 }
 
+func (in ActionInput) Expired(height int) bool {
+	return in.ValidUntil > 0 && height > in.ValidUntil
+}
+
 func (in ActionInput) StringIndented(indent string) string {
 	return fmt.Sprintf(`Input{
 		%s %s Address: %x
 		%s %s Sequence: %v
 		%s %s PubKey: %v
+		%s %s ValidUntil: %v
 		%s}`,
 		indent, indent, in.Address,
 		indent, indent, in.Sequence,
 		indent, indent, in.PubKey,
+		indent, indent, in.ValidUntil,
 		indent)
 }
 
@@ -67,6 +87,24 @@ func NewAction(actionType byte, data []byte) Action {
 	}
 }
 
+// Sub-actions of a batch share its input, so they are
+// covered by one signature and consume one sequence number
+
+type BatchItem struct {
+	Type byte   `json:"type"`
+	Data []byte `json:"data"`
+}
+
+type BatchResult struct {
+	Code tmsp.CodeType `json:"code"`
+	Data []byte        `json:"data"`
+	Log  string        `json:"log"`
+}
+
+func NewBatchAction(items []BatchItem) Action {
+	return NewAction(ActionBatch, wire.BinaryBytes(items))
+}
+
 func (a Action) SignBytes(chainID string) []byte {
 	signBytes := wire.BinaryBytes(chainID)
 	sig := a.Input.Signature
@@ -84,6 +122,12 @@ func (a Action) Prepare(pubKey crypto.PubKey, seq int) {
 	a.Input.Address = pubKey.Address()
 }
 
+// Must be called before Sign, since
+// the signature covers the expiry
+func (a Action) SetValidUntil(height int) {
+	a.Input.ValidUntil = height
+}
+
 func (a Action) Sign(privKey crypto.PrivKey, chainID string) {
 	a.Input.Signature = privKey.Sign(a.SignBytes(chainID))
 }
@@ -0,0 +1,165 @@
+package types
+
+import (
+	tmsp "github.com/tendermint/tmsp/types"
+	"net/http"
+)
+
+// The manager serves a versioned JSON API under /api/v1 next to
+// the older url-encoded routes. Requests are JSON objects with
+// the same fields the older routes take as form values; queries
+// may use GET with URL parameters instead. Responses have data
+// on success and an error with a stable code on failure, with
+// an HTTP status to match. Error messages may change; codes don't.
+
+const APIPrefix = "/api/v1"
+
+// Routes that change state take POST with a JSON body or this
+// header; a form-encoded request without it is refused
+
+const CSRFHeader = "X-Requested-With"
+
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeEncoding         = "encoding_error"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeInvalidSignature = "invalid_signature"
+	ErrCodeInvalidSequence  = "invalid_sequence"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeConflict         = "conflict"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeRejected         = "rejected"
+	ErrCodeTooManyRequests  = "too_many_requests"
+	ErrCodeUnavailable      = "unavailable"
+	ErrCodeInternal         = "internal_error"
+)
+
+// App result codes outside the range TMSP uses
+const (
+	CodeValueNotFound   tmsp.CodeType = 10000
+	CodeVersionNotFound tmsp.CodeType = 10001
+)
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Set when the error is a TMSP result
+	TMSPCode tmsp.CodeType `json:"tmsp_code,omitempty"`
+}
+
+func NewAPIError(code, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+func (err *APIError) Error() string {
+	return err.Message
+}
+
+// Action is set for responses that need more from the
+// client, e.g. "sign_submit_form" with the action to sign
+type APIResponse struct {
+	Action string      `json:"action,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  *APIError   `json:"error,omitempty"`
+}
+
+// Error from a TMSP result; keeps the result code
+type ResultError struct {
+	Code tmsp.CodeType
+	Log  string
+}
+
+func (err *ResultError) Error() string {
+	return err.Log
+}
+
+func (err *ResultError) APIError() (int, *APIError) {
+	status, code := ResultCodeToAPI(err.Code)
+	return status, &APIError{
+		Code:     code,
+		Message:  err.Log,
+		TMSPCode: err.Code,
+	}
+}
+
+func ResultCodeToAPI(code tmsp.CodeType) (int, string) {
+	switch code {
+	case tmsp.CodeType_EncodingError,
+		tmsp.CodeType_BaseEncodingError:
+		return http.StatusBadRequest, ErrCodeEncoding
+	case tmsp.CodeType_UnknownRequest,
+		tmsp.CodeType_BaseInvalidInput,
+		tmsp.CodeType_BaseInvalidOutput,
+		tmsp.CodeType_BaseInvalidPubKey:
+		return http.StatusBadRequest, ErrCodeBadRequest
+	case tmsp.CodeType_Unauthorized:
+		return http.StatusForbidden, ErrCodeForbidden
+	case tmsp.CodeType_BaseInvalidSignature:
+		return http.StatusUnauthorized, ErrCodeInvalidSignature
+	case tmsp.CodeType_BadNonce,
+		tmsp.CodeType_BaseInvalidSequence:
+		return http.StatusConflict, ErrCodeInvalidSequence
+	case tmsp.CodeType_BaseUnknownAddress,
+		tmsp.CodeType_BaseUnknownPubKey,
+		CodeValueNotFound,
+		CodeVersionNotFound:
+		return http.StatusNotFound, ErrCodeNotFound
+	case tmsp.CodeType_BaseDuplicateAddress:
+		return http.StatusConflict, ErrCodeConflict
+	case tmsp.CodeType_InternalError:
+		return http.StatusInternalServerError, ErrCodeInternal
+	}
+	// Other codes are the app rejecting the tx
+	return http.StatusUnprocessableEntity, ErrCodeRejected
+}
+
+// Codes for errors the handlers return
+// before anything reaches the app
+
+func StatusToErrCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusTooManyRequests:
+		return ErrCodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return ErrCodeUnavailable
+	}
+	return ErrCodeInternal
+}
+
+func ErrCodeToStatus(code string) int {
+	switch code {
+	case ErrCodeBadRequest, ErrCodeEncoding:
+		return http.StatusBadRequest
+	case ErrCodeUnauthorized, ErrCodeInvalidSignature:
+		return http.StatusUnauthorized
+	case ErrCodeForbidden:
+		return http.StatusForbidden
+	case ErrCodeNotFound:
+		return http.StatusNotFound
+	case ErrCodeConflict, ErrCodeInvalidSequence:
+		return http.StatusConflict
+	case ErrCodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case ErrCodeRejected:
+		return http.StatusUnprocessableEntity
+	case ErrCodeTooManyRequests:
+		return http.StatusTooManyRequests
+	case ErrCodeUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -1,5 +1,13 @@
 package types
 
+import (
+	"bytes"
+	"sort"
+)
+
+// Cache holds dirty entries on top of a store
+// until they are written back with Sync
+
 type Cache struct {
 	*KVMap
 	store Store
@@ -22,13 +30,50 @@ func (c *Cache) Set(key []byte, value []byte) {
 }
 
 func (c *Cache) Get(key []byte) (value []byte) {
-	value = c.KVMap.Get(key)
-	if value != nil {
-		return
+	if c.KVMap.Has(key) {
+		return c.KVMap.Get(key)
 	}
-	value = c.store.Get(key)
-	c.Set(key, value)
-	return
+	return c.store.Get(key)
+}
+
+// Iterate merges dirty entries with the underlying store;
+// dirty entries shadow the store and nil values are skipped
+
+func (c *Cache) Iterate(start, end []byte, fun func(key, value []byte) bool) (bool, error) {
+	var dirty []*KVNode
+	for kvn := c.KVList.head; kvn != nil; kvn = kvn.next {
+		if InRange(kvn.key, start, end) {
+			dirty = append(dirty, kvn)
+		}
+	}
+	sort.Sort(kvNodes(dirty))
+	stopped, err := c.store.Iterate(start, end, func(key, value []byte) bool {
+		for len(dirty) > 0 && bytes.Compare(dirty[0].key, key) < 0 {
+			kvn := dirty[0]
+			dirty = dirty[1:]
+			if kvn.value != nil && fun(kvn.key, kvn.value) {
+				return true
+			}
+		}
+		if len(dirty) > 0 && bytes.Equal(dirty[0].key, key) {
+			kvn := dirty[0]
+			dirty = dirty[1:]
+			if kvn.value == nil {
+				return false
+			}
+			return fun(kvn.key, kvn.value)
+		}
+		return fun(key, value)
+	})
+	if stopped || err != nil {
+		return stopped, err
+	}
+	for _, kvn := range dirty {
+		if kvn.value != nil && fun(kvn.key, kvn.value) {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (c *Cache) Sync() {
@@ -37,3 +82,9 @@ func (c *Cache) Sync() {
 	}
 	c.Reset()
 }
+
+type kvNodes []*KVNode
+
+func (kvns kvNodes) Len() int           { return len(kvns) }
+func (kvns kvNodes) Less(i, j int) bool { return bytes.Compare(kvns[i].key, kvns[j].key) < 0 }
+func (kvns kvNodes) Swap(i, j int)      { kvns[i], kvns[j] = kvns[j], kvns[i] }
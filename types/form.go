@@ -11,18 +11,30 @@ const FORM_ID_LENGTH = 16
 
 // Info contains the id pair for a submitted form,
 // fields relevant to state filters (issue, location),
-// and submitter so we know when to send a receipt
+// submitter so we know when to send a receipt, and
+// the client's submission time in unix seconds
 
 type Info struct {
-	ContentID *cid.Cid `json:"content_id"`
-	FormID    []byte   `json:"form_id"`
-	Issue     string   `json:"issue"`
-	Location  string   `json:"location"`
-	Submitter string   `json:"submitter"`
+	ContentID   *cid.Cid `json:"content_id"`
+	FormID      []byte   `json:"form_id"`
+	Issue       string   `json:"issue"`
+	Location    string   `json:"location"`
+	SubmittedAt int64    `json:"submitted_at"`
+	Submitter   string   `json:"submitter"`
 }
 
-func NewInfo(contentID *cid.Cid, form Form) Info {
-	return Info{contentID, form.ID(), form.Issue, form.Location, form.Submitter}
+func NewInfo(contentID *cid.Cid, form Form, submittedAt time.Time) Info {
+	return Info{contentID, form.ID(), form.Issue, form.Location, submittedAt.Unix(), form.Submitter}
+}
+
+// FormMeta is recorded in state when the block
+// containing a form is committed. Time is the
+// block time in unix seconds, not the client's.
+// Forms imported from another chain have height 0.
+
+type FormMeta struct {
+	Height int   `json:"height"`
+	Time   int64 `json:"time"`
 }
 
 // Search specifies issue, location and time range
@@ -33,8 +45,16 @@ type Search struct {
 	// Location
 }
 
-func NewSearch(after, before, issue string) Search {
-	return Search{ParseMomentString(after), ParseMomentString(before), issue}
+func NewSearch(after, before, issue string) (Search, error) {
+	afterTime, err := ParseAfter(after)
+	if err != nil {
+		return Search{}, err
+	}
+	beforeTime, err := ParseBefore(before)
+	if err != nil {
+		return Search{}, err
+	}
+	return Search{afterTime, beforeTime, issue}, nil
 }
 
 type Form struct {
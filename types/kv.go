@@ -78,3 +78,15 @@ func (kvm *KVMap) Get(key []byte) []byte {
 	}
 	return nil
 }
+
+func (kvm *KVMap) Has(key []byte) bool {
+	_, ok := kvm.m[BytesToHexstr(key)]
+	return ok
+}
+
+// KVPair is the wire format for range query results
+
+type KVPair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
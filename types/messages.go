@@ -15,15 +15,31 @@ type Message struct {
 }
 
 type Keypair struct {
-	PrivKeystr string `json:"priv_key"`
+	PrivKeystr string `json:"priv_key,omitempty"`
 	PubKeystr  string `json:"pub_key"`
 }
 
+// Returned by create_account. The mnemonic is only ever sent once,
+// when the manager generated the key for a keystore account.
+type NewAccount struct {
+	PubKeystr string `json:"pub_key"`
+	Mnemonic  string `json:"mnemonic,omitempty"`
+	Note      string `json:"note,omitempty"`
+}
+
+// Keys made with GenerateKeypair cannot be recovered from the
+// password, since bcrypt salts at random
+const MnemonicMigrationNote = "Keys are now derived from a 12-word recovery phrase. " +
+	"Keys created from a password before this change cannot be recovered from that password; " +
+	"export your key and store it safely, or create a new account and write down its phrase."
+
 type Idpair struct {
 	FormID    string `json:"form_id"`
 	ContentID string `json:"content_id"`
 }
 
+// Errors keep the result code, see ResultError
+
 func ResultToError(result interface{}) error {
 	switch result.(type) {
 	case *ctypes.ResultTMSPQuery:
@@ -31,13 +47,13 @@ func ResultToError(result interface{}) error {
 		if tmResult.Code == 0 {
 			return nil
 		}
-		return errors.New(tmResult.Error())
+		return &ResultError{tmResult.Code, tmResult.Error()}
 	case *ctypes.ResultBroadcastTx:
 		_result := result.(*ctypes.ResultBroadcastTx)
 		if _result.Code == 0 {
 			return nil
 		}
-		return errors.New(_result.Log)
+		return &ResultError{_result.Code, _result.Log}
 	default:
 		return errors.New("Unrecognized result type")
 	}
@@ -51,9 +67,10 @@ func NewIdpair(form Form, cid *cid.Cid) *Idpair {
 	return &Idpair{BytesToHexstr(form.ID()), cid.String()}
 }
 
-func MessageChainID(err error) *Message {
+func MessageChainID(chainID string, err error) *Message {
 	return &Message{
 		Action: "chain_id",
+		Data:   chainID,
 		Error:  err,
 	}
 }
@@ -66,6 +83,14 @@ func MessageIssues(data []string, err error) *Message {
 	}
 }
 
+func MessageLoginChallenge(challenge string, err error) *Message {
+	return &Message{
+		Action: "login_challenge",
+		Data:   challenge,
+		Error:  err,
+	}
+}
+
 func MessageLogin(err error) *Message {
 	return &Message{
 		Action: "login",
@@ -73,7 +98,24 @@ func MessageLogin(err error) *Message {
 	}
 }
 
-func MessageCreateAccount(data *Keypair, err error) *Message {
+func MessageLogout(err error) *Message {
+	return &Message{
+		Action: "logout",
+		Error:  err,
+	}
+}
+
+// Sent in place of an action's message when the
+// action needs the client's signature
+func MessageSign(action string, data *UnsignedAction, err error) *Message {
+	return &Message{
+		Action: "sign_" + action,
+		Data:   data,
+		Error:  err,
+	}
+}
+
+func MessageCreateAccount(data *NewAccount, err error) *Message {
 	return &Message{
 		Action: "create_account",
 		Data:   data,
@@ -81,6 +123,28 @@ func MessageCreateAccount(data *Keypair, err error) *Message {
 	}
 }
 
+func MessageExportKey(data *Keypair, err error) *Message {
+	return &Message{
+		Action: "export_key",
+		Data:   data,
+		Error:  err,
+	}
+}
+
+func MessageImportKey(err error) *Message {
+	return &Message{
+		Action: "import_key",
+		Error:  err,
+	}
+}
+
+func MessageRecoverAccount(err error) *Message {
+	return &Message{
+		Action: "recover_account",
+		Error:  err,
+	}
+}
+
 func MessageRemoveAccount(err error) *Message {
 	return &Message{
 		Action: "remove_account",
@@ -103,3 +167,19 @@ func MessageFindForm(data *Form, err error) *Message {
 		Error:  err,
 	}
 }
+
+func MessageSearchForms(data []string, err error) *Message {
+	return &Message{
+		Action: "search_forms",
+		Data:   data,
+		Error:  err,
+	}
+}
+
+func MessageFindAccount(data *Account, err error) *Message {
+	return &Message{
+		Action: "find_account",
+		Data:   data,
+		Error:  err,
+	}
+}
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-merkle"
+)
+
+// AbsenceProof shows a key is not in the tree. It holds existence
+// proofs for the key's neighbors: the last key before it and the
+// first key after it. Inner nodes carry subtree sizes in their
+// hashes, so the proofs also show the neighbors are adjacent.
+// Left is nil when the key is before every key, Right when it
+// is after every key, and both when the tree is empty.
+
+type AbsenceProof struct {
+	Left  *merkle.IAVLProof `json:"left"`
+	Right *merkle.IAVLProof `json:"right"`
+}
+
+func (p *AbsenceProof) Verify(key, rootHash []byte) error {
+	if p.Left == nil && p.Right == nil {
+		if len(rootHash) == 0 {
+			return nil
+		}
+		return errors.New("No neighbor proofs for a non-empty tree")
+	}
+	var leftIndex, rightIndex int
+	if p.Left != nil {
+		leaf := p.Left.LeafNode
+		if !p.Left.Verify(leaf.KeyBytes, leaf.ValueBytes, rootHash) {
+			return errors.New("Invalid left neighbor proof")
+		}
+		if bytes.Compare(leaf.KeyBytes, key) >= 0 {
+			return errors.New("Left neighbor is not before key")
+		}
+		leftIndex = proofIndex(p.Left)
+	}
+	if p.Right != nil {
+		leaf := p.Right.LeafNode
+		if !p.Right.Verify(leaf.KeyBytes, leaf.ValueBytes, rootHash) {
+			return errors.New("Invalid right neighbor proof")
+		}
+		if bytes.Compare(leaf.KeyBytes, key) <= 0 {
+			return errors.New("Right neighbor is not after key")
+		}
+		rightIndex = proofIndex(p.Right)
+	}
+	switch {
+	case p.Left == nil:
+		if rightIndex != 0 {
+			return errors.New("Right neighbor is not the first key")
+		}
+	case p.Right == nil:
+		if leftIndex != proofTreeSize(p.Left)-1 {
+			return errors.New("Left neighbor is not the last key")
+		}
+	default:
+		if rightIndex != leftIndex+1 {
+			return errors.New("Neighbors are not adjacent")
+		}
+	}
+	return nil
+}
+
+// Index of the proven leaf in key order. Going up the path,
+// when the leaf is in the right subtree, every leaf in the
+// left subtree comes before it.
+
+func proofIndex(proof *merkle.IAVLProof) int {
+	index, childSize := 0, 1
+	for _, branch := range proof.InnerNodes {
+		if branch.Left != nil {
+			index += branch.Size - childSize
+		}
+		childSize = branch.Size
+	}
+	return index
+}
+
+func proofTreeSize(proof *merkle.IAVLProof) int {
+	if len(proof.InnerNodes) == 0 {
+		return 1
+	}
+	return proof.InnerNodes[len(proof.InnerNodes)-1].Size
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"github.com/tendermint/go-merkle"
+	tndr "github.com/tendermint/tendermint/types"
+	. "github.com/zballs/comit/util"
+)
+
+// A receipt shows a form was committed. AppHash is the app hash
+// in the header at BlockHeight, the first to include the form.
+// With its proof, a receipt can be checked with no network: the
+// merkle proof puts the form in the app hash, the header holds
+// the app hash, and the commit shows the validators signed it.
+// The validators come from the verifier, not the receipt.
+
+type Receipt struct {
+	AppHash     []byte `json:"app_hash"`
+	BlockHeight int    `json:"block_height"`
+	FormID      string `json:"form_id"`
+
+	// Hex of the wire encoded ReceiptProof
+	Proof string `json:"proof,omitempty"`
+}
+
+func NewReceipt(blockHeight int, formID []byte) *Receipt {
+	return &Receipt{BlockHeight: blockHeight, FormID: BytesToHexstr(formID)}
+}
+
+// The commit is the next block's last commit
+
+type ReceiptProof struct {
+	Value  []byte            `json:"value"`
+	Proof  *merkle.IAVLProof `json:"proof"`
+	Header *tndr.Header      `json:"header"`
+	Commit *tndr.Commit      `json:"commit"`
+}
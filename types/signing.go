@@ -0,0 +1,36 @@
+package types
+
+import (
+	"github.com/tendermint/go-wire"
+	. "github.com/zballs/comit/util"
+)
+
+// Clients hold their own keys. The manager prepares an action
+// and returns its sign bytes; the client signs them and sends
+// back the signature, which the manager checks and broadcasts.
+
+type UnsignedAction struct {
+	ID        string `json:"id"`
+	Action    Action `json:"action"`
+	SignBytes string `json:"sign_bytes"`
+}
+
+func NewUnsignedAction(action Action, chainID string) *UnsignedAction {
+	return &UnsignedAction{
+		ID:        BytesToHexstr(action.ID(chainID)),
+		Action:    action,
+		SignBytes: BytesToHexstr(action.SignBytes(chainID)),
+	}
+}
+
+// Login proves a client holds the key for an account by
+// signing a one-time challenge. The chain ID and prefix keep
+// the signature from being valid as an action.
+
+const LoginPrefix = "comit/login/"
+
+func LoginSignBytes(chainID string, challenge []byte) []byte {
+	signBytes := wire.BinaryBytes(chainID)
+	signBytes = append(signBytes, LoginPrefix...)
+	return append(signBytes, challenge...)
+}
@@ -0,0 +1,26 @@
+package types
+
+// Snapshot holds all committed state at a height: the merkle
+// tree's items and root hash, plus the app state kept outside
+// the tree. Version is bumped whenever the format changes.
+
+const SnapshotVersion = 2
+
+type Snapshot struct {
+	Version int      `json:"version"`
+	ChainID string   `json:"chain_id"`
+	Height  int      `json:"height"`
+	AppHash []byte   `json:"app_hash"`
+	Issues  []string `json:"issues"`
+	Items   []KVPair `json:"items"`
+
+	Filters []IssueFilter `json:"filters"`
+}
+
+// Form IDs in an issue's search filter. Filters are
+// kept outside the tree, so they are rebuilt on import.
+
+type IssueFilter struct {
+	Issue   string   `json:"issue"`
+	FormIDs [][]byte `json:"form_ids"`
+}
@@ -1,10 +1,35 @@
 package types
 
-import . "github.com/zballs/comit/util"
+import (
+	"bytes"
+	. "github.com/zballs/comit/util"
+	"sort"
+)
+
+// Setting a nil value removes the key from the store.
+// Iterate visits keys in [start, end) in ascending order
+// until fun returns true. A nil end means no upper bound.
+// An error means the keys could not be read, and fun may
+// have seen only some of them.
 
 type Store interface {
 	Set(key, value []byte)
 	Get(key []byte) (value []byte)
+	Iterate(start, end []byte, fun func(key, value []byte) bool) (stopped bool, err error)
+}
+
+func IteratePrefix(store Store, prefix []byte, fun func(key, value []byte) bool) (bool, error) {
+	return store.Iterate(prefix, PrefixEnd(prefix), fun)
+}
+
+func InRange(key, start, end []byte) bool {
+	if bytes.Compare(key, start) < 0 {
+		return false
+	}
+	if end != nil && bytes.Compare(key, end) >= 0 {
+		return false
+	}
+	return true
 }
 
 type MemStore struct {
@@ -18,9 +43,32 @@ func NewMemStore() *MemStore {
 }
 
 func (mstore *MemStore) Set(key []byte, value []byte) {
+	if value == nil {
+		delete(mstore.m, BytesToHexstr(key))
+		return
+	}
 	mstore.m[BytesToHexstr(key)] = value
 }
 
 func (mstore *MemStore) Get(key []byte) (value []byte) {
 	return mstore.m[BytesToHexstr(key)]
 }
+
+func (mstore *MemStore) Iterate(start, end []byte, fun func(key, value []byte) bool) (bool, error) {
+	// Hex strings sort in the same order as the bytes they encode
+	keystrs := make([]string, 0, len(mstore.m))
+	for keystr, _ := range mstore.m {
+		keystrs = append(keystrs, keystr)
+	}
+	sort.Strings(keystrs)
+	for _, keystr := range keystrs {
+		key := HexstrToBytes(keystr)
+		if !InRange(key, start, end) {
+			continue
+		}
+		if fun(key, mstore.m[keystr]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
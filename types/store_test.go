@@ -0,0 +1,107 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Keys with values other than the ones setKeys writes
+// are returned as key=value
+
+func iterateKeys(t *testing.T, store Store, start, end []byte, stopAt string) []string {
+	var keys []string
+	_, err := store.Iterate(start, end, func(key, value []byte) bool {
+		if string(value) == "value "+string(key) {
+			keys = append(keys, string(key))
+		} else {
+			keys = append(keys, string(key)+"="+string(value))
+		}
+		return string(key) == stopAt
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func setKeys(store Store, keys ...string) {
+	for _, key := range keys {
+		store.Set([]byte(key), []byte("value "+key))
+	}
+}
+
+func TestMemStoreIterate(t *testing.T) {
+	store := NewMemStore()
+	setKeys(store, "d", "b", "a", "c", "e")
+	store.Set([]byte("e"), nil)
+
+	cases := []struct {
+		start, end []byte
+		want       []string
+	}{
+		{nil, nil, []string{"a", "b", "c", "d"}},
+		{[]byte("b"), []byte("d"), []string{"b", "c"}},
+		{[]byte("bb"), nil, []string{"c", "d"}},
+		{[]byte("x"), nil, nil},
+	}
+	for _, c := range cases {
+		got := iterateKeys(t, store, c.start, c.end, "")
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("[%s, %s): expected %v, got %v", c.start, c.end, c.want, got)
+		}
+	}
+
+	got := iterateKeys(t, store, nil, nil, "b")
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Expected iteration to stop at b, got %v", got)
+	}
+}
+
+func TestCacheIterate(t *testing.T) {
+	store := NewMemStore()
+	setKeys(store, "b", "d", "f", "h")
+
+	cache := NewCache(store)
+	cache.Set([]byte("b"), []byte("new")) // overwritten
+	cache.Set([]byte("d"), nil)           // deleted, then set again
+	cache.Set([]byte("d"), []byte("again"))
+	cache.Set([]byte("f"), nil)   // deleted
+	setKeys(cache, "a", "e", "i") // new, before, between and after
+	cache.Set([]byte("g"), nil)   // deleted, never stored
+
+	cases := []struct {
+		start, end []byte
+		want       []string
+	}{
+		{nil, nil, []string{"a", "b=new", "d=again", "e", "h", "i"}},
+		{[]byte("c"), []byte("h"), []string{"d=again", "e"}},
+		{[]byte("f"), []byte("g"), nil},
+		{[]byte("h"), nil, []string{"h", "i"}},
+	}
+	for _, c := range cases {
+		got := iterateKeys(t, cache, c.start, c.end, "")
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("[%s, %s): expected %v, got %v", c.start, c.end, c.want, got)
+		}
+	}
+
+	// Stopping on a dirty key, a stored key and a dirty
+	// key after the last stored key
+	for _, stopAt := range []string{"e", "h", "i"} {
+		keys := iterateKeys(t, cache, nil, nil, stopAt)
+		if len(keys) == 0 || keys[len(keys)-1] != stopAt {
+			t.Errorf("Expected iteration to stop at %s, got %v", stopAt, keys)
+		}
+	}
+
+	// The store is untouched until Sync
+	got := iterateKeys(t, store, nil, nil, "")
+	if !reflect.DeepEqual(got, []string{"b", "d", "f", "h"}) {
+		t.Errorf("Expected store keys before sync, got %v", got)
+	}
+	cache.Sync()
+	got = iterateKeys(t, store, nil, nil, "")
+	if !reflect.DeepEqual(got, []string{"a", "b=new", "d=again", "e", "h", "i"}) {
+		t.Errorf("Expected store keys after sync, got %v", got)
+	}
+}
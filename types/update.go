@@ -2,27 +2,41 @@ package types
 
 import (
 	"github.com/pkg/errors"
-	. "github.com/zballs/comit/util"
 )
 
 type Update struct {
-	Error   error    `json:"error, omitempty"`
-	Form    *Form    `json:"form, omitempty"`
-	Receipt *Receipt `json:"receipt, omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Form    *Form    `json:"form,omitempty"`
+	Receipt *Receipt `json:"receipt,omitempty"`
 	Type    string   `json:"type"`
 }
 
+// A nil value with an error is an "error" update,
+// e.g. when the block stream fails and restarts
+
 func NewUpdate(v interface{}, err error) (*Update, error) {
+	var errstr string
+	if err != nil {
+		errstr = err.Error()
+	}
 	switch v.(type) {
+	case nil:
+		if err == nil {
+			return nil, errors.New("Error update without an error")
+		}
+		return &Update{
+			Error: errstr,
+			Type:  "error",
+		}, nil
 	case *Form:
 		return &Update{
-			Error: err,
+			Error: errstr,
 			Form:  v.(*Form),
 			Type:  "form",
 		}, nil
 	case *Receipt:
 		return &Update{
-			Error:   err,
+			Error:   errstr,
 			Receipt: v.(*Receipt),
 			Type:    "receipt",
 		}, nil
@@ -30,13 +44,3 @@ func NewUpdate(v interface{}, err error) (*Update, error) {
 		return nil, errors.New("Unrecognized update type")
 	}
 }
-
-type Receipt struct {
-	AppHash     []byte `json:"app_hash"`
-	BlockHeight int    `json:"block_height"`
-	FormID      string `json:"form_id"`
-}
-
-func NewReceipt(blockHeight int, formID []byte) *Receipt {
-	return &Receipt{BlockHeight: blockHeight, FormID: BytesToHexstr(formID)}
-}
@@ -0,0 +1,15 @@
+package types
+
+import "fmt"
+
+// Upgrade activates a version of the execution
+// rules at the given block height
+
+type Upgrade struct {
+	Height  int `json:"height"`
+	Version int `json:"version"`
+}
+
+func (u Upgrade) String() string {
+	return fmt.Sprintf("Upgrade{Height: %v, Version: %v}", u.Height, u.Version)
+}
@@ -0,0 +1,18 @@
+package types
+
+import (
+	"fmt"
+	"github.com/tendermint/go-crypto"
+)
+
+// Validator sets a validator's voting power.
+// Zero power removes the validator.
+
+type Validator struct {
+	PubKey crypto.PubKey `json:"pub_key"`
+	Power  uint64        `json:"power"`
+}
+
+func (v Validator) String() string {
+	return fmt.Sprintf("Validator{PubKey: %v, Power: %v}", v.PubKey, v.Power)
+}
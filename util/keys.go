@@ -7,8 +7,49 @@ import (
 	bcrypt "golang.org/x/crypto/bcrypt"
 )
 
+// Keys and signatures are hex encoded with their go-crypto type
+// byte first, e.g. 01 for Ed25519 and 02 for secp256k1. Hex
+// without the type byte, of the old fixed lengths, is Ed25519.
+
+const (
+	KeyTypeEd25519   = "ed25519"
+	KeyTypeSecp256k1 = "secp256k1"
+)
+
+// Lengths of untyped Ed25519 hex encodings
 const PUBKEY_LENGTH = 32
 const PRIVKEY_LENGTH = 64
+const SIGNATURE_LENGTH = 64
+
+func GenPrivKey(keyType string) (crypto.PrivKey, error) {
+	switch keyType {
+	case KeyTypeEd25519, "":
+		return crypto.GenPrivKeyEd25519(), nil
+	case KeyTypeSecp256k1:
+		return crypto.GenPrivKeySecp256k1(), nil
+	}
+	return nil, errors.Errorf("Unknown key type %q", keyType)
+}
+
+func PrivKeyFromSecret(keyType string, secret []byte) (crypto.PrivKey, error) {
+	switch keyType {
+	case KeyTypeEd25519, "":
+		return crypto.GenPrivKeyEd25519FromSecret(secret), nil
+	case KeyTypeSecp256k1:
+		return crypto.GenPrivKeySecp256k1FromSecret(secret), nil
+	}
+	return nil, errors.Errorf("Unknown key type %q", keyType)
+}
+
+func KeyType(pubKey crypto.PubKey) string {
+	switch pubKey.(type) {
+	case crypto.PubKeyEd25519:
+		return KeyTypeEd25519
+	case crypto.PubKeySecp256k1:
+		return KeyTypeSecp256k1
+	}
+	return ""
+}
 
 // Generate secret from password string
 
@@ -21,6 +62,10 @@ func GenerateSecret(password string) ([]byte, error) {
 }
 
 // Generate keypair from password string
+//
+// Deprecated: bcrypt salts at random, so the same password gives
+// a different key each time and cannot recover it. Use a
+// mnemonic, see KeypairFromMnemonic.
 
 func GenerateKeypair(password string) (crypto.PubKey, crypto.PrivKey, error) {
 	secret, err := GenerateSecret(password)
@@ -28,40 +73,79 @@ func GenerateKeypair(password string) (crypto.PubKey, crypto.PrivKey, error) {
 		return nil, nil, err
 	}
 	privKey := crypto.GenPrivKeyEd25519FromSecret(secret)
-	pubKey := privKey.PubKey().(crypto.PubKeyEd25519)
-	return pubKey, privKey, nil
+	return privKey.PubKey(), privKey, nil
 }
 
 // Keys to hex strings
 
 func PubKeytoHexstr(pubKey crypto.PubKey) string {
-	pubKeyEd25519 := pubKey.(crypto.PubKeyEd25519)
-	return BytesToHexstr(pubKeyEd25519[:])
+	return BytesToHexstr(pubKey.Bytes())
 }
 
 func PrivKeytoHexstr(privKey crypto.PrivKey) string {
-	privKeyEd25519 := privKey.(crypto.PrivKeyEd25519)
-	return BytesToHexstr(privKeyEd25519[:])
+	return BytesToHexstr(privKey.Bytes())
+}
+
+func SignaturetoHexstr(sig crypto.Signature) string {
+	return BytesToHexstr(sig.Bytes())
 }
 
 // Hex strings to keys
 
 func PubKeyfromHexstr(pubKeystr string) (crypto.PubKey, error) {
-	var pubKey crypto.PubKeyEd25519
 	pubKeyBytes, err := hex.DecodeString(pubKeystr)
-	if err != nil || len(pubKeyBytes) != PUBKEY_LENGTH {
+	if err != nil {
+		return nil, errors.New("Invalid public key")
+	}
+	if len(pubKeyBytes) == PUBKEY_LENGTH {
+		var pubKey crypto.PubKeyEd25519
+		copy(pubKey[:], pubKeyBytes)
+		return pubKey, nil
+	}
+	pubKey, err := crypto.PubKeyFromBytes(pubKeyBytes)
+	if err != nil || pubKey == nil {
 		return nil, errors.New("Invalid public key")
 	}
-	copy(pubKey[:], pubKeyBytes)
 	return pubKey, nil
 }
 
 func PrivKeyfromHexstr(privKeystr string) (crypto.PrivKey, error) {
-	var privKey crypto.PrivKeyEd25519
 	privKeyBytes, err := hex.DecodeString(privKeystr)
-	if err != nil || len(privKeyBytes) != PRIVKEY_LENGTH {
+	if err != nil {
+		return nil, errors.New("Invalid private key")
+	}
+	if len(privKeyBytes) == PRIVKEY_LENGTH {
+		var privKey crypto.PrivKeyEd25519
+		copy(privKey[:], privKeyBytes)
+		return privKey, nil
+	}
+	privKey, err := crypto.PrivKeyFromBytes(privKeyBytes)
+	if err != nil || privKey == nil {
 		return nil, errors.New("Invalid private key")
 	}
-	copy(privKey[:], privKeyBytes)
 	return privKey, nil
 }
+
+func SignaturefromHexstr(sigstr string) (crypto.Signature, error) {
+	sigBytes, err := hex.DecodeString(sigstr)
+	if err != nil {
+		return nil, errors.New("Invalid signature")
+	}
+	if len(sigBytes) == SIGNATURE_LENGTH {
+		var sig crypto.SignatureEd25519
+		copy(sig[:], sigBytes)
+		return sig, nil
+	}
+	sig, err := crypto.SignatureFromBytes(sigBytes)
+	if err != nil || sig == nil {
+		return nil, errors.New("Invalid signature")
+	}
+	return sig, nil
+}
+
+// Compares hex encoded keys by key, so typed
+// and untyped Ed25519 strings for a key match
+func PubKeyHexstrEqual(pubKeystr string, pubKey crypto.PubKey) bool {
+	other, err := PubKeyfromHexstr(pubKeystr)
+	return err == nil && other.Equals(pubKey)
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"github.com/tendermint/go-crypto"
+	"testing"
+)
+
+func TestKeyHexstrs(t *testing.T) {
+	for _, keyType := range []string{KeyTypeEd25519, KeyTypeSecp256k1} {
+		privKey, err := GenPrivKey(keyType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKey := privKey.PubKey()
+		if KeyType(pubKey) != keyType {
+			t.Errorf("Expected key type %v, got %v", keyType, KeyType(pubKey))
+		}
+
+		pubKey2, err := PubKeyfromHexstr(PubKeytoHexstr(pubKey))
+		if err != nil || !pubKey2.Equals(pubKey) {
+			t.Errorf("%v: public key did not round trip: %v", keyType, err)
+		}
+		privKey2, err := PrivKeyfromHexstr(PrivKeytoHexstr(privKey))
+		if err != nil || !privKey2.Equals(privKey) {
+			t.Errorf("%v: private key did not round trip: %v", keyType, err)
+		}
+		msg := []byte("message")
+		sig, err := SignaturefromHexstr(SignaturetoHexstr(privKey.Sign(msg)))
+		if err != nil || !pubKey.VerifyBytes(msg, sig) {
+			t.Errorf("%v: signature did not round trip: %v", keyType, err)
+		}
+	}
+
+	// Untyped hex is Ed25519
+	privKey := crypto.GenPrivKeyEd25519()
+	pubKey := privKey.PubKey().(crypto.PubKeyEd25519)
+	legacy := BytesToHexstr(pubKey[:])
+	if !PubKeyHexstrEqual(legacy, pubKey) {
+		t.Error("Expected untyped hex to decode as Ed25519")
+	}
+	if !PubKeyHexstrEqual(PubKeytoHexstr(pubKey), pubKey) {
+		t.Error("Expected typed hex to match")
+	}
+	sig := privKey.Sign([]byte("message")).(crypto.SignatureEd25519)
+	_, err := SignaturefromHexstr(BytesToHexstr(sig[:]))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := PubKeyfromHexstr("XYZ"); err == nil {
+		t.Error("Expected invalid hex to be rejected")
+	}
+	if _, err := GenPrivKey("rsa"); err == nil {
+		t.Error("Expected unknown key type to be rejected")
+	}
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"github.com/pkg/errors"
+	"github.com/tendermint/go-crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+)
+
+// Keys are derived from a mnemonic phrase, so a user who
+// wrote the phrase down can recover their key. Phrases and
+// seeds follow BIP-39: 128 bits of entropy plus a checksum
+// give 12 words, and the seed is PBKDF2-SHA512 of the phrase.
+// The key, of any type, is generated from the seed.
+
+const (
+	MnemonicEntropyBits = 128
+	MnemonicLength      = 12
+
+	mnemonicIterations = 2048
+	mnemonicSeedLength = 64
+)
+
+var mnemonicIndex = make(map[string]int)
+
+func init() {
+	if len(MnemonicWords) != 2048 {
+		panic("Mnemonic word list must have 2048 words")
+	}
+	for i, word := range MnemonicWords {
+		mnemonicIndex[word] = i
+	}
+}
+
+func NewMnemonic() (string, error) {
+	entropy := make([]byte, MnemonicEntropyBits/8)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return "", errors.Wrap(err, "generating entropy")
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// Each word holds 11 bits of the entropy followed
+// by the first len(entropy)/4 bits of its SHA-256
+
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy)*8 != MnemonicEntropyBits {
+		return "", errors.Errorf("Entropy must be %d bits", MnemonicEntropyBits)
+	}
+	hash := sha256.Sum256(entropy)
+	bits := append(append([]byte{}, entropy...), hash[0])
+	words := make([]string, MnemonicLength)
+	for i := range words {
+		index := 0
+		for j := 0; j < 11; j++ {
+			index = index<<1 | bit(bits, i*11+j)
+		}
+		words[i] = MnemonicWords[index]
+	}
+	return strings.Join(words, " "), nil
+}
+
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) != MnemonicLength {
+		return nil, errors.Errorf("Mnemonic must have %d words", MnemonicLength)
+	}
+	bits := make([]byte, MnemonicEntropyBits/8+1)
+	for i, word := range words {
+		index, ok := mnemonicIndex[strings.ToLower(word)]
+		if !ok {
+			return nil, errors.Errorf("Unknown mnemonic word %q", word)
+		}
+		for j := 0; j < 11; j++ {
+			if index&(1<<uint(10-j)) != 0 {
+				pos := i*11 + j
+				bits[pos/8] |= 1 << uint(7-pos%8)
+			}
+		}
+	}
+	entropy := bits[:MnemonicEntropyBits/8]
+	hash := sha256.Sum256(entropy)
+	checksumBits := uint(MnemonicEntropyBits / 32)
+	mask := byte(0xFF) << (8 - checksumBits)
+	if bits[len(entropy)]&mask != hash[0]&mask {
+		return nil, errors.New("Invalid mnemonic checksum")
+	}
+	return entropy, nil
+}
+
+func bit(bz []byte, pos int) int {
+	return int(bz[pos/8]>>uint(7-pos%8)) & 1
+}
+
+func MnemonicSeed(mnemonic, passphrase string) []byte {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	salt := []byte("mnemonic" + passphrase)
+	return pbkdf2.Key([]byte(mnemonic), salt, mnemonicIterations, mnemonicSeedLength, sha512.New)
+}
+
+// The same phrase and key type always give the same keypair
+func KeypairFromMnemonic(keyType, mnemonic string) (crypto.PubKey, crypto.PrivKey, error) {
+	_, err := MnemonicToEntropy(mnemonic)
+	if err != nil {
+		return nil, nil, err
+	}
+	seed := MnemonicSeed(mnemonic, "")
+	privKey, err := PrivKeyFromSecret(keyType, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey.PubKey(), privKey, nil
+}
@@ -0,0 +1,57 @@
+package util
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Vectors from https://github.com/trezor/python-mnemonic/blob/master/vectors.json
+var mnemonicVectors = []struct {
+	entropy  string
+	mnemonic string
+	seed     string
+}{
+	{
+		"00000000000000000000000000000000",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+}
+
+func TestMnemonic(t *testing.T) {
+	for _, v := range mnemonicVectors {
+		entropy, _ := hex.DecodeString(v.entropy)
+		mnemonic, err := EntropyToMnemonic(entropy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mnemonic != v.mnemonic {
+			t.Errorf("Expected %q, got %q", v.mnemonic, mnemonic)
+		}
+		decoded, err := MnemonicToEntropy(v.mnemonic)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(decoded) != v.entropy {
+			t.Errorf("Expected entropy %v, got %x", v.entropy, decoded)
+		}
+		if seed := hex.EncodeToString(MnemonicSeed(v.mnemonic, "TREZOR")); seed != v.seed {
+			t.Errorf("Expected seed %v, got %v", v.seed, seed)
+		}
+	}
+
+	// Last word carries the checksum
+	_, err := MnemonicToEntropy("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon")
+	if err == nil {
+		t.Error("Expected invalid checksum")
+	}
+	_, err = MnemonicToEntropy("abandon abandon abandon")
+	if err == nil {
+		t.Error("Expected too few words to be rejected")
+	}
+}
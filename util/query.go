@@ -13,6 +13,19 @@ const (
 	// App specfic
 	QueryIssues byte = 5
 	QuerySearch byte = 6
+	QueryRange  byte = 7
+
+	// Pending sequence in the app's check state
+	QuerySequence byte = 8
+
+	// All committed state, see types.Snapshot
+	QueryState byte = 9
+
+	// Wraps a tree query to read committed state at a height
+	QueryAtHeight byte = 10
+
+	// Neighbor proofs for a missing key, see types.AbsenceProof
+	QueryAbsenceProof byte = 11
 )
 
 func EmptyQuery(QueryType byte) []byte {
@@ -40,3 +53,64 @@ func IndexQuery(i int) []byte {
 	query = query[:n+1]
 	return query
 }
+
+// HeightQuery reads the tree as committed at height,
+// as long as that version has not been pruned
+
+func HeightQuery(height int, query []byte) []byte {
+	buf := make([]byte, 10)
+	buf[0] = QueryAtHeight
+	n, err := wire.PutVarint(buf[1:], height)
+	if err != nil {
+		return nil
+	}
+	return append(buf[:n+1], query...)
+}
+
+// Most pairs a range query returns at once; callers page
+// through larger ranges by starting after the last key
+const MaxRangeLimit = 1000
+
+// Range query over keys in [start, end); empty end means no upper bound.
+// A limit of 0, or above MaxRangeLimit, means MaxRangeLimit.
+
+func RangeQuery(start, end []byte, limit int) []byte {
+	query := make([]byte, wire.ByteSliceSize(start)+wire.ByteSliceSize(end)+10)
+	buf := query
+	buf[0] = QueryRange
+	buf = buf[1:]
+	n, err := wire.PutByteSlice(buf, start)
+	if err != nil {
+		return nil
+	}
+	buf = buf[n:]
+	n, err = wire.PutByteSlice(buf, end)
+	if err != nil {
+		return nil
+	}
+	buf = buf[n:]
+	n, err = wire.PutVarint(buf, limit)
+	if err != nil {
+		return nil
+	}
+	return query[:len(query)-len(buf)+n]
+}
+
+func PrefixQuery(prefix []byte, limit int) []byte {
+	return RangeQuery(prefix, PrefixEnd(prefix), limit)
+}
+
+// PrefixEnd returns the first key after every key with prefix,
+// or nil if no such key exists (e.g. prefix is all 0xFF)
+
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xFF {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
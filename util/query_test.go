@@ -0,0 +1,31 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	cases := []struct {
+		prefix, end []byte
+	}{
+		{[]byte("base/a/"), []byte("base/a0")},
+		{[]byte{0x01, 0xFF}, []byte{0x02}},
+		{[]byte{0x01, 0xFF, 0xFF}, []byte{0x02}},
+		{[]byte{0xFF, 0xFF}, nil},
+		{[]byte{}, nil},
+	}
+	for _, c := range cases {
+		end := PrefixEnd(c.prefix)
+		if !bytes.Equal(end, c.end) || (c.end == nil) != (end == nil) {
+			t.Errorf("%X: expected end %X, got %X", c.prefix, c.end, end)
+		}
+	}
+
+	// The prefix is not modified
+	prefix := []byte{0x01, 0x02}
+	PrefixEnd(prefix)
+	if !bytes.Equal(prefix, []byte{0x01, 0x02}) {
+		t.Errorf("Expected prefix to be unchanged, got %X", prefix)
+	}
+}
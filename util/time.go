@@ -1,90 +1,165 @@
 package util
 
-import "strconv"
-import "time"
+import (
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
 
 const MomentLength = 32
 
+// Municipal timezone, used to display times and to read
+// times and dates that do not carry a zone of their own
+var Timezone = time.Local
+
+func SetTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return errors.Wrap(err, "Invalid timezone")
+	}
+	Timezone = loc
+	return nil
+}
+
 func TimeString() string {
-	return time.Now().UTC().String()
+	return FormatTime(time.Now())
+}
+
+func FormatTime(t time.Time) string {
+	return t.In(Timezone).Format(time.RFC3339)
+}
+
+// Layouts with a zone, tried first
+var zonedLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05.999999999 -0700 MST", // time.Time.String()
+}
+
+// Older layouts without a zone, read in Timezone
+var localLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"01/02/2006 15:04",   // moment
+	"01/02/2006 3:04 PM", // moment, 12-hour
+}
+
+const dateLayout = "2006-01-02"
+
+const momentDateLayout = "01/02/2006"
+
+func ParseTimeString(timestr string) (time.Time, error) {
+	timestr = strings.TrimSpace(timestr)
+	if timestr == "" {
+		return time.Time{}, errors.New("Empty time string")
+	}
+	// time.Time.String() may append a monotonic clock reading
+	if idx := strings.Index(timestr, " m="); idx >= 0 {
+		timestr = timestr[:idx]
+	}
+	for _, layout := range zonedLayouts {
+		if t, err := time.Parse(layout, timestr); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, timestr, Timezone); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := ParseDateString(timestr); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.Errorf("Invalid time %q; expected RFC 3339", timestr)
+}
+
+// Moment strings come from the datetime picker on the citizen page
+func ParseMomentString(momentstr string) (time.Time, error) {
+	return ParseTimeString(momentstr)
 }
 
-func ParseTimeString(timestr string) time.Time {
-	yr, _ := strconv.Atoi(timestr[:4])
-	mo, _ := strconv.Atoi(timestr[5:7])
-	d, _ := strconv.Atoi(timestr[8:10])
-	hr, _ := strconv.Atoi(timestr[11:13])
-	min, _ := strconv.Atoi(timestr[14:16])
-	sec, _ := strconv.Atoi(timestr[17:19])
-	return time.Date(yr, time.Month(mo), d, hr, min, sec, 0, time.Local)
+func ParseMinuteString(minutestr string) (time.Time, error) {
+	t, err := ParseTimeString(minutestr)
+	if err != nil {
+		return t, err
+	}
+	return t.Truncate(time.Minute), nil
 }
 
-var months = map[string]int{
-	"Jan": 1,
-	"Feb": 2,
-	"Mar": 3,
-	"Apr": 4,
-	"May": 5,
-	"Jun": 6,
-	"Jul": 7,
-	"Aug": 8,
-	"Sep": 9,
-	"Oct": 10,
-	"Nov": 11,
-	"Dec": 12,
+// Dates are midnight in Timezone
+func ParseDateString(datestr string) (time.Time, error) {
+	datestr = strings.TrimSpace(datestr)
+	for _, layout := range []string{dateLayout, momentDateLayout} {
+		if t, err := time.ParseInLocation(layout, datestr, Timezone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("Invalid date %q; expected YYYY-MM-DD", datestr)
 }
 
-func ParseMomentString(momentstr string) time.Time {
-	yr, _ := strconv.Atoi(momentstr[6:10])
-	mo, _ := months[momentstr[:2]]
-	d, _ := strconv.Atoi(momentstr[3:5])
-	hr, _ := strconv.Atoi(momentstr[11:13])
-	min, _ := strconv.Atoi(momentstr[14:16])
-	return time.Date(yr, time.Month(mo), d, hr, min, 0, 0, time.Local)
+// Search bounds. An empty string leaves the range open.
+// A date without a time covers the whole day in Timezone,
+// so "before" a date means before the end of that day.
+
+func ParseAfter(after string) (time.Time, error) {
+	if strings.TrimSpace(after) == "" {
+		return time.Time{}, nil
+	}
+	return ParseTimeString(after)
 }
 
-func ParseDateString(datestr string) time.Time {
-	yr, _ := strconv.Atoi(datestr[:4])
-	mo, _ := strconv.Atoi(datestr[5:7])
-	d, _ := strconv.Atoi(datestr[8:10])
-	return time.Date(yr, time.Month(mo), d, 0, 0, 0, 0, time.Local)
+func ParseBefore(before string) (time.Time, error) {
+	if strings.TrimSpace(before) == "" {
+		return time.Time{}, nil
+	}
+	if t, err := ParseDateString(before); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	return ParseTimeString(before)
 }
 
-func ParseMinuteString(minutestr string) time.Time {
-	yr, _ := strconv.Atoi(minutestr[:4])
-	mo, _ := strconv.Atoi(minutestr[5:7])
-	d, _ := strconv.Atoi(minutestr[8:10])
-	hr, _ := strconv.Atoi(minutestr[11:13])
-	min, _ := strconv.Atoi(minutestr[14:16])
-	return time.Date(yr, time.Month(mo), d, hr, min, 0, 0, time.Local)
+func DurationTimeStrings(timestr1, timestr2 string) (time.Duration, error) {
+	tm1, err := ParseTimeString(timestr1)
+	if err != nil {
+		return 0, err
+	}
+	tm2, err := ParseTimeString(timestr2)
+	if err != nil {
+		return 0, err
+	}
+	return tm2.Sub(tm1), nil
 }
 
-func DurationTimeStrings(timestr1, timestr2 string) time.Duration {
-	tm1 := ParseTimeString(timestr1)
-	tm2 := ParseTimeString(timestr2)
-	return tm2.Sub(tm1)
+func DurationHours(timestr1, timestr2 string) (float64, error) {
+	d, err := DurationTimeStrings(timestr1, timestr2)
+	return d.Hours(), err
 }
 
-func DurationHours(timestr1, timestr2 string) float64 {
-	return DurationTimeStrings(timestr1, timestr2).Hours()
+func DurationDays(timestr1, timestr2 string) (float64, error) {
+	hours, err := DurationHours(timestr1, timestr2)
+	return hours / float64(24), err
 }
 
-func DurationDays(timestr1, timestr2 string) float64 {
-	return DurationHours(timestr1, timestr2) / float64(24)
+func truncate(timestr string, n int) string {
+	if len(timestr) < n {
+		return timestr
+	}
+	return timestr[:n]
 }
 
 func ToTheDay(timestr string) string {
-	return timestr[:10]
+	return truncate(timestr, 10)
 }
 
 func ToTheHour(timestr string) string {
-	return timestr[:13]
+	return truncate(timestr, 13)
 }
 
 func ToTheMinute(timestr string) string {
-	return timestr[:16]
+	return truncate(timestr, 16)
 }
 
 func ToTheSecond(timestr string) string {
-	return timestr[:19]
+	return truncate(timestr, 19)
 }
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeString(t *testing.T) {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip("timezone data unavailable")
+	}
+	Timezone = loc
+	defer func() { Timezone = time.Local }()
+
+	want := time.Date(2016, 11, 3, 14, 5, 0, 0, loc)
+	valid := []string{
+		"2016-11-03T14:05:00-05:00",
+		"2016-11-03T19:05:00Z",
+		"2016-11-03 14:05:00 -0500 CDT",
+		"2016-11-03 14:05:00.000000001 -0500 CDT m=+0.000000001",
+		"2016-11-03 14:05",
+		"11/03/2016 14:05",
+		"11/03/2016 2:05 PM",
+	}
+	for _, str := range valid {
+		got, err := ParseTimeString(str)
+		if err != nil {
+			t.Errorf("%q: %v", str, err)
+			continue
+		}
+		if !got.Truncate(time.Second).Equal(want) {
+			t.Errorf("%q: got %v, want %v", str, got, want)
+		}
+	}
+
+	invalid := []string{"", "2016", "11/03", "2016-13-45T00:00:00Z", "yesterday"}
+	for _, str := range invalid {
+		if _, err := ParseTimeString(str); err == nil {
+			t.Errorf("%q: expected error", str)
+		}
+	}
+}
+
+func TestParseSearchBounds(t *testing.T) {
+	Timezone = time.UTC
+	defer func() { Timezone = time.Local }()
+
+	after, err := ParseAfter("2016-11-03")
+	if err != nil || !after.Equal(time.Date(2016, 11, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected after bound: %v, %v", after, err)
+	}
+	before, err := ParseBefore("2016-11-03")
+	if err != nil || !before.Equal(time.Date(2016, 11, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected before bound: %v, %v", before, err)
+	}
+	open, err := ParseBefore("")
+	if err != nil || !open.IsZero() {
+		t.Errorf("Expected open bound, got %v, %v", open, err)
+	}
+}